@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+
+	"github.com/samirettali/tor-spider/pkg/warc"
+)
+
+// archiveResponse writes the request and the response of a crawled page to
+// the WARC files. The payload is the body as decoded by the collector, so the
+// encoding headers are dropped and the Content-Length is set to its size.
+func (spider *Spider) archiveResponse(r *colly.Response) {
+	var request bytes.Buffer
+	fmt.Fprintf(&request, "%s %s HTTP/1.1\r\nHost: %s\r\n", r.Request.Method, r.Request.URL.RequestURI(), r.Request.URL.Host)
+	if r.Request.Headers != nil {
+		r.Request.Headers.Write(&request)
+	}
+	request.WriteString("\r\n")
+
+	header := http.Header{}
+	if r.Headers != nil {
+		header = r.Headers.Clone()
+	}
+	header.Del(proxyHeader)
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(r.Body)))
+	var response bytes.Buffer
+	fmt.Fprintf(&response, "HTTP/1.1 %d %s\r\n", r.StatusCode, http.StatusText(r.StatusCode))
+	header.Write(&response)
+	response.WriteString("\r\n")
+	response.Write(r.Body)
+
+	metadata := map[string]string{
+		"depth": strconv.Itoa(r.Request.Depth),
+	}
+	if r.Headers != nil && r.Headers.Get(proxyHeader) != "" {
+		metadata["proxy"] = r.Headers.Get(proxyHeader)
+	}
+	err := spider.archive.WriteExchange(r.Request.URL.String(), time.Now(), request.Bytes(), response.Bytes(), r.Body, metadata)
+	if err != nil {
+		spider.Logger.Error(err)
+	}
+}
+
+// replay processes the responses archived in WARC files as if they had just
+// been crawled, without fetching anything
+func (spider *Spider) replay(paths []string) error {
+	spider.offline = true
+	if err := spider.loadSimHashes(); err != nil {
+		return err
+	}
+	if err := spider.pageStorage.Init(); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		replayed, err := spider.replayFile(path)
+		if err != nil {
+			spider.Logger.Errorf("Could not replay %s: %v", path, err)
+			continue
+		}
+		spider.Logger.Infof("Replayed %d responses of %s", replayed, path)
+	}
+	return spider.pageStorage.Close()
+}
+
+// replayFile processes the response records of a WARC file
+func (spider *Spider) replayFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	reader, err := warc.NewReader(f)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return replayed, nil
+		}
+		if err != nil {
+			return replayed, err
+		}
+		if record.Type() != warc.Response {
+			continue
+		}
+		r, err := replayResponse(record)
+		if err != nil {
+			spider.Logger.Debugf("Skipping %s: %v", record.Header.Get(warc.TargetURI), err)
+			continue
+		}
+		spider.processResponse(r)
+		replayed++
+	}
+}
+
+// replayResponse returns the collector response of a response record
+func replayResponse(record *warc.Record) (*colly.Response, error) {
+	target, err := url.Parse(record.Header.Get(warc.TargetURI))
+	if err != nil {
+		return nil, err
+	}
+	res, err := record.ReadResponse()
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := colly.NewContext()
+	if date := record.Date(); !date.IsZero() {
+		ctx.Put("crawledAt", date)
+	}
+	return &colly.Response{
+		StatusCode: res.StatusCode,
+		Body:       body,
+		Ctx:        ctx,
+		Headers:    &res.Header,
+		Request: &colly.Request{
+			URL:     target,
+			Headers: &http.Header{},
+			Ctx:     ctx,
+			Method:  "GET",
+		},
+	}, nil
+}
@@ -2,30 +2,98 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esutil"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/samirettali/tor-spider/pkg/urlnorm"
 )
 
-// ElasticPageStorage is an implementation of the PageStorage interface
+// ElasticPageStorage is an implementation of the PageStorage interface. Pages
+// are indexed in the background by bulk requests, sent when BufferSize pages
+// or FlushBytes bytes are buffered, or every FlushInterval. Documents failing
+// with a transient error are retried, the others are written to the
+// DeadLetter file.
+//
+// Documents are identified by their canonical URL, so that recrawls replace
+// them. When Rollover is set, Index is an alias rolled over to a new index
+// after RolloverAge or RolloverDocs documents, and a recrawl only replaces the
+// document of a page in the current index.
 type ElasticPageStorage struct {
-	URI        string
-	Index      string
-	BufferSize int
-	Logger     *log.Logger
-	pages      chan PageInfo
+	URI           string
+	Index         string
+	BufferSize    int
+	FlushBytes    int
+	FlushInterval time.Duration
+	Workers       int
+	MaxRetries    int
+	DeadLetter    string
+	Rollover      bool
+	RolloverAge   time.Duration
+	RolloverDocs  int
+	Canonicalizer *urlnorm.Canonicalizer
+	Logger        *log.Logger
+
+	pages      chan elasticDocument
+	batches    chan []elasticDocument
 	client     *elasticsearch.Client
+	deadLetter *os.File
+	workers    sync.WaitGroup
+	done       chan struct{}
+	flushNow   chan struct{}
+	closed     bool
+	failed     uint64
+	onFlush    func(error)
+	mu         sync.RWMutex
+	deadMu     sync.Mutex
+}
+
+// elasticDocument is a page waiting to be indexed
+type elasticDocument struct {
+	ID   string
+	URL  string
+	Body []byte
 }
 
-// Init initializes the connection to elastic search
+// bulkFailure is a document of a bulk request which could not be indexed
+type bulkFailure struct {
+	DocumentError
+	Doc elasticDocument
+}
+
+// deadLetterEntry is a line of the dead letter file
+type deadLetterEntry struct {
+	DocumentError
+	Time     time.Time       `json:"time"`
+	Document json.RawMessage `json:"document"`
+}
+
+// Init initializes the connection to elastic search and starts the indexer
 func (e *ElasticPageStorage) Init() error {
-	e.pages = make(chan PageInfo, e.BufferSize)
+	if e.BufferSize <= 0 {
+		e.BufferSize = 100
+	}
+	if e.FlushBytes <= 0 {
+		e.FlushBytes = 5 << 20
+	}
+	if e.FlushInterval <= 0 {
+		e.FlushInterval = 30 * time.Second
+	}
+	if e.Workers <= 0 {
+		e.Workers = 2
+	}
+	if e.MaxRetries <= 0 {
+		e.MaxRetries = 3
+	}
+
 	retryBackoff := backoff.NewExponentialBackOff()
 	var err error
 	e.client, err = elasticsearch.NewClient(elasticsearch.Config{
@@ -44,64 +112,294 @@ func (e *ElasticPageStorage) Init() error {
 		},
 		MaxRetries: 5,
 	})
-	return err
-}
+	if err != nil {
+		return err
+	}
 
-// SavePage adds a page to the channel pages
-func (e *ElasticPageStorage) SavePage(page PageInfo) error {
-	select {
-	case e.pages <- page:
-		return nil
-	default:
-		err := e.flush()
+	if err := e.installTemplate(); err != nil {
+		return err
+	}
+	if e.Rollover {
+		if err := e.bootstrapAlias(); err != nil {
+			return err
+		}
+	}
+
+	if e.DeadLetter != "" {
+		e.deadLetter, err = os.OpenFile(e.DeadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			return err
 		}
-		e.pages <- page
-		return nil
 	}
-}
 
-func (e *ElasticPageStorage) flush() error {
-	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
-		Index:         e.Index,
-		Client:        e.client,
-		NumWorkers:    8,
-		FlushBytes:    int(1000000),
-		FlushInterval: 30 * time.Second,
-	})
+	e.pages = make(chan elasticDocument, e.BufferSize)
+	e.batches = make(chan []elasticDocument, e.Workers)
+	e.done = make(chan struct{})
+	e.flushNow = make(chan struct{}, 1)
+	e.workers.Add(e.Workers)
+	for i := 0; i < e.Workers; i++ {
+		go e.worker()
+	}
+	go e.batch()
+	return nil
+}
 
+// SavePage queues a page for indexing. It is safe for concurrent use.
+func (e *ElasticPageStorage) SavePage(page PageInfo) error {
+	data, err := json.Marshal(newElasticPage(page))
 	if err != nil {
 		return err
 	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return &SavePageError{Err: "page storage is closed"}
+	}
+	e.pages <- elasticDocument{ID: e.documentID(page.URL), URL: page.URL, Body: data}
+	return nil
+}
 
-	for i := 0; i < e.BufferSize; i++ {
-		data, err := json.Marshal(<-e.pages)
-		if err != nil {
-			return err
+// SetFlushHandler sets a function called with the result of every bulk
+// request, an error when Elasticsearch is failing. It must be called before
+// Init.
+func (e *ElasticPageStorage) SetFlushHandler(handler func(error)) {
+	e.onFlush = handler
+}
+
+// Flush indexes the queued pages without waiting for the flush interval
+func (e *ElasticPageStorage) Flush() {
+	select {
+	case e.flushNow <- struct{}{}:
+	default:
+	}
+}
+
+// documentID returns the id of the document of a page, the hash of its
+// canonical URL
+func (e *ElasticPageStorage) documentID(pageURL string) string {
+	if e.Canonicalizer != nil {
+		if canonical, err := e.Canonicalizer.Canonicalize(pageURL); err == nil {
+			pageURL = canonical
 		}
-		err = bi.Add(
-			context.Background(),
-			esutil.BulkIndexerItem{
-				Action: "index",
-				Body:   bytes.NewReader(data),
-			},
-		)
-		if err != nil {
+	}
+	return strToMD5(pageURL)
+}
+
+// Close indexes all the buffered pages and stops the indexer
+func (e *ElasticPageStorage) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.pages)
+	e.mu.Unlock()
+
+	<-e.done
+	e.workers.Wait()
+	if e.deadLetter != nil {
+		if err := e.deadLetter.Close(); err != nil {
 			return err
 		}
 	}
+	if failed := atomic.LoadUint64(&e.failed); failed > 0 {
+		return &SavePageError{Err: fmt.Sprintf("Failed to index %d documents", failed)}
+	}
+	return nil
+}
 
-	if err := bi.Close(context.Background()); err != nil {
-		return err
+// batch groups the queued pages and hands them to the workers
+func (e *ElasticPageStorage) batch() {
+	defer close(e.done)
+	defer close(e.batches)
+	ticker := time.NewTicker(e.FlushInterval)
+	defer ticker.Stop()
+
+	// the nil channel of the rollover checks blocks when they are disabled
+	var rollover <-chan time.Time
+	if e.Rollover && (e.RolloverAge > 0 || e.RolloverDocs > 0) {
+		rolloverTicker := time.NewTicker(rolloverCheckInterval)
+		defer rolloverTicker.Stop()
+		rollover = rolloverTicker.C
+	}
+
+	var batch []elasticDocument
+	size := 0
+	flush := func() {
+		if len(batch) > 0 {
+			e.batches <- batch
+			batch, size = nil, 0
+		}
+	}
+	for {
+		select {
+		case doc, ok := <-e.pages:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, doc)
+			size += len(doc.Body)
+			if len(batch) >= e.BufferSize || size >= e.FlushBytes {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.flushNow:
+			// take the pages queued before the request
+			for len(e.pages) > 0 {
+				doc := <-e.pages
+				batch = append(batch, doc)
+				size += len(doc.Body)
+			}
+			flush()
+		case <-rollover:
+			if err := e.rollover(); err != nil {
+				e.Logger.Error(err)
+			}
+		}
 	}
+}
 
-	biStats := bi.Stats()
+// worker indexes the batches, retrying the documents which failed with a
+// transient error
+func (e *ElasticPageStorage) worker() {
+	defer e.workers.Done()
+	for batch := range e.batches {
+		retryBackoff := backoff.NewExponentialBackOff()
+		pending := batch
+		var failures []bulkFailure
+		for attempt := 0; len(pending) > 0; attempt++ {
+			var retry []elasticDocument
+			for _, failure := range e.flush(pending) {
+				if isTransient(failure.Status) && attempt < e.MaxRetries {
+					retry = append(retry, failure.Doc)
+				} else {
+					failures = append(failures, failure)
+				}
+			}
+			if len(retry) > 0 {
+				e.Logger.Debugf("Retrying %d documents", len(retry))
+				time.Sleep(retryBackoff.NextBackOff())
+			}
+			pending = retry
+		}
+		e.report(batch, failures)
+		if e.onFlush != nil {
+			e.onFlush(flushError(failures))
+		}
+	}
+}
 
-	if biStats.NumFailed > 0 {
-		msg := fmt.Sprintf("Failed to index %d documents", biStats.NumFailed)
-		return &SavePageError{msg}
+// flushError returns an error when some documents could not be indexed
+// because Elasticsearch is failing, rather than because they were rejected
+func flushError(failures []bulkFailure) error {
+	for _, failure := range failures {
+		if isTransient(failure.Status) {
+			return fmt.Errorf("could not index %s: %s", failure.URL, failure.Reason)
+		}
 	}
-	e.Logger.Infof("Saved %d pages", biStats.NumAdded)
 	return nil
 }
+
+// isTransient tells whether a failed document may be indexed by retrying
+func isTransient(status int) bool {
+	return status == 0 || status == 429 || status >= 500
+}
+
+// flush sends a bulk request and returns the failures of its documents. A
+// status of 0 means the request itself failed.
+func (e *ElasticPageStorage) flush(docs []elasticDocument) []bulkFailure {
+	var body bytes.Buffer
+	for _, doc := range docs {
+		meta, _ := json.Marshal(map[string]interface{}{
+			"index": map[string]string{"_id": doc.ID},
+		})
+		body.Write(meta)
+		body.WriteByte('\n')
+		body.Write(doc.Body)
+		body.WriteByte('\n')
+	}
+
+	failAll := func(status int, reason string) []bulkFailure {
+		failures := make([]bulkFailure, 0, len(docs))
+		for _, doc := range docs {
+			failures = append(failures, bulkFailure{
+				DocumentError: DocumentError{ID: doc.ID, URL: doc.URL, Status: status, Reason: reason},
+				Doc:           doc,
+			})
+		}
+		return failures
+	}
+
+	res, err := e.client.Bulk(&body, e.client.Bulk.WithIndex(e.Index))
+	if err != nil {
+		return failAll(0, err.Error())
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return failAll(res.StatusCode, res.String())
+	}
+
+	var blk esutil.BulkIndexerResponse
+	if err := json.NewDecoder(res.Body).Decode(&blk); err != nil {
+		return failAll(0, fmt.Sprintf("could not parse the bulk response: %v", err))
+	}
+	var failures []bulkFailure
+	for i, item := range blk.Items {
+		if i >= len(docs) {
+			break
+		}
+		for _, info := range item {
+			if info.Error.Type == "" && info.Status <= 201 {
+				continue
+			}
+			failures = append(failures, bulkFailure{
+				DocumentError: DocumentError{
+					ID:     docs[i].ID,
+					URL:    docs[i].URL,
+					Status: info.Status,
+					Type:   info.Error.Type,
+					Reason: info.Error.Reason,
+				},
+				Doc: docs[i],
+			})
+		}
+	}
+	return failures
+}
+
+// report logs the result of a batch and dead-letters its failed documents
+func (e *ElasticPageStorage) report(batch []elasticDocument, failures []bulkFailure) {
+	if len(failures) == 0 {
+		e.Logger.Infof("Saved %d pages", len(batch))
+		return
+	}
+	atomic.AddUint64(&e.failed, uint64(len(failures)))
+	errs := make([]DocumentError, 0, len(failures))
+	for _, failure := range failures {
+		errs = append(errs, failure.DocumentError)
+	}
+	e.Logger.Error(&SavePageError{
+		Err:      fmt.Sprintf("Failed to index %d of %d documents", len(failures), len(batch)),
+		Failures: errs,
+	})
+	if e.deadLetter == nil {
+		return
+	}
+
+	e.deadMu.Lock()
+	defer e.deadMu.Unlock()
+	enc := json.NewEncoder(e.deadLetter)
+	for _, failure := range failures {
+		entry := deadLetterEntry{
+			DocumentError: failure.DocumentError,
+			Time:          time.Now(),
+			Document:      failure.Doc.Body,
+		}
+		if err := enc.Encode(entry); err != nil {
+			e.Logger.Error(err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// fakeBulk answers the bulk requests with the statuses of the documents given
+// by status, and records the ids of the documents of each request
+type fakeBulk struct {
+	status   func(id string, attempt int) int
+	requests [][]string
+	attempts map[string]int
+	mu       sync.Mutex
+}
+
+func (f *fakeBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	var items []string
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var meta struct {
+			Index struct {
+				ID string `json:"_id"`
+			} `json:"index"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil || meta.Index.ID == "" {
+			continue
+		}
+		// skip the document
+		scanner.Scan()
+
+		id := meta.Index.ID
+		ids = append(ids, id)
+		status := f.status(id, f.attempts[id])
+		f.attempts[id]++
+		item := fmt.Sprintf(`{"index":{"_id":%q,"status":%d}}`, id, status)
+		if status > 201 {
+			item = fmt.Sprintf(`{"index":{"_id":%q,"status":%d,"error":{"type":"error_%d","reason":"failed"}}}`, id, status, status)
+		}
+		items = append(items, item)
+	}
+	f.requests = append(f.requests, ids)
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+}
+
+func TestElasticWorker(t *testing.T) {
+	bulk := &fakeBulk{
+		attempts: make(map[string]int),
+		status: func(id string, attempt int) int {
+			switch {
+			case id == "rejected":
+				return 400
+			case id == "busy" && attempt == 0:
+				return 429
+			}
+			return 201
+		},
+	}
+	server := httptest.NewServer(bulk)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "elastic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	deadLetter, err := os.Create(filepath.Join(dir, "dead.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	var flushErrors []error
+	e := &ElasticPageStorage{
+		Index:      "pages",
+		MaxRetries: 2,
+		Logger:     logger,
+		client:     client,
+		deadLetter: deadLetter,
+		batches:    make(chan []elasticDocument, 1),
+		onFlush: func(err error) {
+			flushErrors = append(flushErrors, err)
+		},
+	}
+	var batch []elasticDocument
+	for _, id := range []string{"saved", "busy", "rejected"} {
+		batch = append(batch, elasticDocument{ID: id, URL: "http://" + id + ".onion/", Body: []byte(`{}`)})
+	}
+	e.batches <- batch
+	close(e.batches)
+	e.workers.Add(1)
+	e.worker()
+	deadLetter.Close()
+
+	expected := [][]string{{"saved", "busy", "rejected"}, {"busy"}}
+	if !reflect.DeepEqual(bulk.requests, expected) {
+		t.Errorf("only the busy document should be retried, got %v", bulk.requests)
+	}
+	if e.failed != 1 {
+		t.Errorf("1 document should have failed, got %d", e.failed)
+	}
+	if len(flushErrors) != 1 || flushErrors[0] != nil {
+		t.Errorf("a rejected document should not be a flush error, got %v", flushErrors)
+	}
+
+	data, err := ioutil.ReadFile(deadLetter.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("only the rejected document should be dead-lettered, got %q", data)
+	}
+	var entry deadLetterEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.ID != "rejected" || entry.Status != 400 || entry.Type != "error_400" || string(entry.Document) != `{}` {
+		t.Errorf("unexpected dead letter entry %+v", entry)
+	}
+}
+
+func TestElasticWorkerUnavailable(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}, DisableRetry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	var flushErrors []error
+	e := &ElasticPageStorage{
+		Index:      "pages",
+		MaxRetries: 1,
+		Logger:     logger,
+		client:     client,
+		batches:    make(chan []elasticDocument, 1),
+		onFlush: func(err error) {
+			flushErrors = append(flushErrors, err)
+		},
+	}
+	e.batches <- []elasticDocument{{ID: "a", URL: "http://a.onion/", Body: []byte(`{}`)}}
+	close(e.batches)
+	e.workers.Add(1)
+	e.worker()
+
+	if requests != 2 {
+		t.Errorf("the batch should be sent twice, got %d requests", requests)
+	}
+	if e.failed != 1 {
+		t.Errorf("1 document should have failed, got %d", e.failed)
+	}
+	if len(flushErrors) != 1 || flushErrors[0] == nil {
+		t.Errorf("an unavailable server should be a flush error, got %v", flushErrors)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// elasticTemplateVersion is the version of the index template, to increase
+// whenever the mapping changes
+const elasticTemplateVersion = 1
+
+// rolloverCheckInterval is the interval between the rollover checks
+const rolloverCheckInterval = time.Hour
+
+// languageAnalyzers are the elasticsearch analyzers of the languages detected
+// by whatlanggo
+var languageAnalyzers = map[string]string{
+	"Arabic":     "arabic",
+	"Bengali":    "bengali",
+	"Bokmal":     "norwegian",
+	"Bulgarian":  "bulgarian",
+	"Czech":      "czech",
+	"Danish":     "danish",
+	"Dutch":      "dutch",
+	"English":    "english",
+	"Estonian":   "estonian",
+	"Finnish":    "finnish",
+	"French":     "french",
+	"German":     "german",
+	"Greek":      "greek",
+	"Hindi":      "hindi",
+	"Hungarian":  "hungarian",
+	"Indonesian": "indonesian",
+	"Italian":    "italian",
+	"Japanese":   "cjk",
+	"Korean":     "cjk",
+	"Latvian":    "latvian",
+	"Lithuanian": "lithuanian",
+	"Mandarin":   "cjk",
+	"Nynorsk":    "norwegian",
+	"Persian":    "persian",
+	"Portuguese": "portuguese",
+	"Romanian":   "romanian",
+	"Russian":    "russian",
+	"Spanish":    "spanish",
+	"Swedish":    "swedish",
+	"Thai":       "thai",
+	"Turkish":    "turkish",
+}
+
+// elasticPage is the document of a page. The text of the page is copied to
+// the Localized field of its language, which is analyzed for that language.
+type elasticPage struct {
+	PageInfo
+	CreatedAt  time.Time         `json:"CreatedAt"`
+	UpdatedAt  time.Time         `json:"UpdatedAt"`
+	Attributes []PageProperty    `json:"Attributes,omitempty"`
+	Localized  map[string]string `json:"Localized,omitempty"`
+}
+
+func newElasticPage(page PageInfo) elasticPage {
+	doc := elasticPage{
+		PageInfo:   page,
+		CreatedAt:  page.CreatedAt,
+		UpdatedAt:  page.UpdatedAt,
+		Attributes: page.PageProperties,
+	}
+	if analyzer, ok := languageAnalyzers[page.Language]; ok {
+		doc.Localized = map[string]string{
+			analyzer: strings.TrimSpace(page.Title + "\n" + page.Summary),
+		}
+	}
+	return doc
+}
+
+// elasticTemplate returns the index template of the pages of the given index
+// or alias
+func elasticTemplate(index string) map[string]interface{} {
+	text := map[string]interface{}{"type": "text"}
+	keyword := map[string]interface{}{"type": "keyword", "ignore_above": 1024}
+	textKeyword := map[string]interface{}{
+		"type": "text",
+		"fields": map[string]interface{}{
+			"raw": keyword,
+		},
+	}
+
+	analyzers := make(map[string]bool)
+	for _, analyzer := range languageAnalyzers {
+		analyzers[analyzer] = true
+	}
+	var names []string
+	for analyzer := range analyzers {
+		names = append(names, analyzer)
+	}
+	sort.Strings(names)
+	localized := make(map[string]interface{})
+	for _, analyzer := range names {
+		localized[analyzer] = map[string]interface{}{"type": "text", "analyzer": analyzer}
+	}
+
+	return map[string]interface{}{
+		"index_patterns": []string{index, index + "-*"},
+		"version":        elasticTemplateVersion,
+		"settings": map[string]interface{}{
+			"number_of_shards": 1,
+		},
+		"mappings": map[string]interface{}{
+			"dynamic": false,
+			"properties": map[string]interface{}{
+				"URL":            keyword,
+				"Body":           text,
+				"Summary":        text,
+				"KeyPoints":      text,
+				"Title":          textKeyword,
+				"Keywords":       textKeyword,
+				"Category":       keyword,
+				"Domain":         keyword,
+				"IsHomePage":     map[string]interface{}{"type": "boolean"},
+				"Status":         map[string]interface{}{"type": "integer"},
+				"Language":       keyword,
+				"cluster_id":     map[string]interface{}{"type": "long"},
+				"near_duplicate": keyword,
+				"CreatedAt":      map[string]interface{}{"type": "date"},
+				"UpdatedAt":      map[string]interface{}{"type": "date"},
+				"Attributes": map[string]interface{}{
+					"type": "nested",
+					"properties": map[string]interface{}{
+						"Name":  keyword,
+						"Value": keyword,
+					},
+				},
+				"Localized": map[string]interface{}{
+					"properties": localized,
+				},
+			},
+		},
+	}
+}
+
+// installTemplate installs the index template of the pages, unless the same
+// or a newer version is already installed
+func (e *ElasticPageStorage) installTemplate() error {
+	name := e.Index + "-template"
+	res, err := e.client.Indices.GetTemplate(e.client.Indices.GetTemplate.WithName(name))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if !res.IsError() {
+		var templates map[string]struct {
+			Version int `json:"version"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&templates); err != nil {
+			return err
+		}
+		if templates[name].Version >= elasticTemplateVersion {
+			return nil
+		}
+	}
+
+	body, err := json.Marshal(elasticTemplate(e.Index))
+	if err != nil {
+		return err
+	}
+	res, err = e.client.Indices.PutTemplate(name, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("could not install the index template: %s", res.String())
+	}
+	e.Logger.Infof("Installed version %d of the index template %s", elasticTemplateVersion, name)
+	return nil
+}
+
+// bootstrapAlias creates the first index of the rollover alias Index if it
+// does not exist yet
+func (e *ElasticPageStorage) bootstrapAlias() error {
+	res, err := e.client.Indices.ExistsAlias([]string{e.Index})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == 200 {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"aliases": map[string]interface{}{
+			e.Index: map[string]interface{}{"is_write_index": true},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	index := e.Index + "-000001"
+	res, err = e.client.Indices.Create(index, e.client.Indices.Create.WithBody(bytes.NewReader(body)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("could not create the index %s: %s", index, res.String())
+	}
+	e.Logger.Infof("Created the index %s for the alias %s", index, e.Index)
+	return nil
+}
+
+// rollover creates a new write index for the alias Index when the current one
+// is older than RolloverAge or holds more than RolloverDocs documents
+func (e *ElasticPageStorage) rollover() error {
+	conditions := make(map[string]interface{})
+	if e.RolloverAge > 0 {
+		conditions["max_age"] = fmt.Sprintf("%ds", int(e.RolloverAge.Seconds()))
+	}
+	if e.RolloverDocs > 0 {
+		conditions["max_docs"] = e.RolloverDocs
+	}
+	body, err := json.Marshal(map[string]interface{}{"conditions": conditions})
+	if err != nil {
+		return err
+	}
+	res, err := e.client.Indices.Rollover(e.Index, e.client.Indices.Rollover.WithBody(bytes.NewReader(body)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("could not roll over %s: %s", e.Index, res.String())
+	}
+	var result struct {
+		RolledOver bool   `json:"rolled_over"`
+		NewIndex   string `json:"new_index"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.RolledOver {
+		e.Logger.Infof("Rolled over %s to %s", e.Index, result.NewIndex)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"github.com/samirettali/tor-spider/pkg/extract"
+)
+
+// maxClusterSize is the maximum number of domains of an entity cluster
+const maxClusterSize = 1000
+
+// maxClusterHops is the maximum number of shared identifiers between two
+// domains of an entity cluster
+const maxClusterHops = 3
+
+// Entity is an identifier, like an email, a wallet or a PGP key, deduplicated
+// across the pages mentioning it
+type Entity struct {
+	gorm.Model
+	Type      string `gorm:"size:32;unique_index:type_value"`
+	ValueHash string `gorm:"size:32;unique_index:type_value"`
+	Value     string `gorm:"type:text"`
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// EntityOccurrence links an entity to a page mentioning it
+type EntityOccurrence struct {
+	gorm.Model
+	EntityID  uint   `gorm:"unique_index:entity_page"`
+	URLHash   string `gorm:"size:32;unique_index:entity_page"`
+	URL       string `gorm:"type:text"`
+	Domain    string `gorm:"index:domain"`
+	ServiceID uint   `gorm:"index:service_id"`
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// EntityDomain is a domain mentioning an entity
+type EntityDomain struct {
+	Domain    string    `json:"domain"`
+	Service   string    `json:"service,omitempty"`
+	Pages     int       `json:"pages"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// ClusterMember is a domain connected to an entity through shared identifiers
+type ClusterMember struct {
+	Domain  string `json:"domain"`
+	Service string `json:"service,omitempty"`
+}
+
+// EntityPivot lists the domains mentioning an entity, and the cluster of the
+// domains and services connected to them by shared identifiers
+type EntityPivot struct {
+	Type      string          `json:"type"`
+	Value     string          `json:"value"`
+	FirstSeen time.Time       `json:"first_seen"`
+	LastSeen  time.Time       `json:"last_seen"`
+	Domains   []EntityDomain  `json:"domains"`
+	Cluster   []ClusterMember `json:"cluster"`
+}
+
+// isIdentifier tells whether attributes named name identify who runs a
+// service. Onions are links: the popular ones would join most of the domains
+// in a single cluster.
+func isIdentifier(name string) bool {
+	return name != extract.Onion
+}
+
+// indexEntities saves the identifiers found in a page as entities. Their first
+// and last sightings only widen to seenAt, as replayed pages can be older than
+// the crawled ones.
+func (spider *Spider) indexEntities(pageURL, domain string, attrs []extract.Attribute, seenAt time.Time) {
+	if len(attrs) == 0 {
+		return
+	}
+	var serviceID uint
+	if u, err := url.Parse(pageURL); err == nil {
+		serviceID = spider.serviceID(u.Hostname())
+	}
+	urlHash := strToMD5(pageURL)
+
+	for _, attr := range attrs {
+		if !isIdentifier(attr.Name) {
+			continue
+		}
+		var entity Entity
+		err := spider.rdbms.
+			Where(Entity{Type: attr.Name, ValueHash: strToMD5(attr.Value)}).
+			Attrs(Entity{Value: attr.Value, FirstSeen: seenAt, LastSeen: seenAt}).
+			FirstOrCreate(&entity).Error
+		if err != nil {
+			spider.Logger.Error(err)
+			continue
+		}
+		if err := spider.widenSightings(&Entity{}, entity.ID, seenAt); err != nil {
+			spider.Logger.Error(err)
+		}
+
+		var occurrence EntityOccurrence
+		err = spider.rdbms.
+			Where(EntityOccurrence{EntityID: entity.ID, URLHash: urlHash}).
+			Attrs(EntityOccurrence{URL: pageURL, FirstSeen: seenAt, LastSeen: seenAt}).
+			Assign(EntityOccurrence{Domain: domain, ServiceID: serviceID}).
+			FirstOrCreate(&occurrence).Error
+		if err != nil {
+			spider.Logger.Error(err)
+			continue
+		}
+		if err := spider.widenSightings(&EntityOccurrence{}, occurrence.ID, seenAt); err != nil {
+			spider.Logger.Error(err)
+		}
+	}
+}
+
+// widenSightings moves the first or the last sighting of a row to seenAt when
+// it is outside of them
+func (spider *Spider) widenSightings(model interface{}, id uint, seenAt time.Time) error {
+	err := spider.rdbms.Model(model).Where("id = ? AND first_seen > ?", id, seenAt).UpdateColumn("first_seen", seenAt).Error
+	if err != nil {
+		return err
+	}
+	return spider.rdbms.Model(model).Where("id = ? AND last_seen < ?", id, seenAt).UpdateColumn("last_seen", seenAt).Error
+}
+
+// serviceNames returns the names of the given services
+func (spider *Spider) serviceNames(ids []uint) (map[uint]string, error) {
+	names := make(map[uint]string)
+	if len(ids) == 0 {
+		return names, nil
+	}
+	var services []Service
+	if err := spider.rdbms.Where("id IN (?)", ids).Find(&services).Error; err != nil {
+		return nil, err
+	}
+	for _, svc := range services {
+		names[svc.ID] = svc.Name
+	}
+	return names, nil
+}
+
+// pivot returns the domains mentioning an entity and their cluster. The value
+// is normalized like the extractors do.
+func (spider *Spider) pivot(entityType, value string) (*EntityPivot, error) {
+	value = extract.Normalize(entityType, value)
+	var entity Entity
+	if spider.rdbms.Where("type = ? AND value_hash = ?", entityType, strToMD5(value)).First(&entity).RecordNotFound() {
+		return nil, nil
+	}
+
+	var rows []struct {
+		Domain    string
+		ServiceID uint
+		Pages     int
+		FirstSeen time.Time
+		LastSeen  time.Time
+	}
+	err := spider.rdbms.Model(&EntityOccurrence{}).
+		Select("domain, service_id, COUNT(*) AS pages, MIN(first_seen) AS first_seen, MAX(last_seen) AS last_seen").
+		Where("entity_id = ?", entity.ID).
+		Group("domain, service_id").
+		Order("pages desc").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	var domains []string
+	for _, row := range rows {
+		ids = append(ids, row.ServiceID)
+		domains = append(domains, row.Domain)
+	}
+	names, err := spider.serviceNames(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	pivot := &EntityPivot{
+		Type:      entity.Type,
+		Value:     entity.Value,
+		FirstSeen: entity.FirstSeen,
+		LastSeen:  entity.LastSeen,
+		Domains:   []EntityDomain{},
+	}
+	for _, row := range rows {
+		pivot.Domains = append(pivot.Domains, EntityDomain{
+			Domain:    row.Domain,
+			Service:   names[row.ServiceID],
+			Pages:     row.Pages,
+			FirstSeen: row.FirstSeen,
+			LastSeen:  row.LastSeen,
+		})
+	}
+
+	pivot.Cluster, err = spider.entityCluster(domains)
+	return pivot, err
+}
+
+// entityCluster returns the domains connected to the given ones by chains of
+// shared identifiers. The onions indexed as entities before they were
+// excluded are skipped.
+func (spider *Spider) entityCluster(domains []string) ([]ClusterMember, error) {
+	links := spider.rdbms.Model(&Entity{}).Select("id").Where("type = ?", extract.Onion).SubQuery()
+	visited := make(map[string]bool)
+	for _, domain := range domains {
+		visited[domain] = true
+	}
+	frontier := domains
+	for hop := 0; hop < maxClusterHops && len(frontier) > 0 && len(visited) < maxClusterSize; hop++ {
+		var entityIDs []uint
+		err := spider.rdbms.Model(&EntityOccurrence{}).
+			Where("domain IN (?) AND entity_id NOT IN (?)", frontier, links).
+			Pluck("DISTINCT entity_id", &entityIDs).Error
+		if err != nil {
+			return nil, err
+		}
+		var connected []string
+		err = spider.rdbms.Model(&EntityOccurrence{}).
+			Where("entity_id IN (?)", entityIDs).
+			Pluck("DISTINCT domain", &connected).Error
+		if err != nil {
+			return nil, err
+		}
+		frontier = nil
+		for _, domain := range connected {
+			if !visited[domain] && len(visited) < maxClusterSize {
+				visited[domain] = true
+				frontier = append(frontier, domain)
+			}
+		}
+	}
+
+	cluster := make([]string, 0, len(visited))
+	for domain := range visited {
+		cluster = append(cluster, domain)
+	}
+	sort.Strings(cluster)
+
+	var rows []struct {
+		Domain    string
+		ServiceID uint
+	}
+	err := spider.rdbms.Model(&EntityOccurrence{}).
+		Select("DISTINCT domain, service_id").
+		Where("domain IN (?) AND service_id <> 0", cluster).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint
+	services := make(map[string]uint)
+	for _, row := range rows {
+		ids = append(ids, row.ServiceID)
+		services[row.Domain] = row.ServiceID
+	}
+	names, err := spider.serviceNames(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]ClusterMember, 0, len(cluster))
+	for _, domain := range cluster {
+		members = append(members, ClusterMember{Domain: domain, Service: names[services[domain]]})
+	}
+	return members, nil
+}
+
+// parseEntity splits a type:value command line argument
+func parseEntity(arg string) (string, string, error) {
+	parts := strings.SplitN(arg, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New("entity must be given as type:value")
+	}
+	return parts[0], parts[1], nil
+}
+
+// entityHandler serves the pivot of the entity given by the type and value
+// params
+func (spider *Spider) entityHandler(c *gin.Context) {
+	value := strings.TrimPrefix(c.Param("value"), "/")
+	pivot, err := spider.pivot(c.Param("type"), value)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if pivot == nil {
+		c.String(404, "entity not found")
+		return
+	}
+	c.JSON(200, pivot)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Kinds of failure of a fetch
+const (
+	FailureTimeout            = "timeout"
+	FailureConnectionRefused  = "connection_refused"
+	FailureSocks              = "socks_failure"
+	FailureDescriptorNotFound = "hs_descriptor_not_found"
+	FailureUnreachable        = "hs_unreachable"
+	FailureClientAuth         = "hs_client_auth"
+	FailureBadAddress         = "hs_bad_address"
+	FailureRateLimited        = "http_429"
+	FailureServerError        = "http_5xx"
+	FailureClientError        = "http_4xx"
+	FailureOther              = "other"
+)
+
+// torReplies maps the messages of the SOCKS5 replies sent by Tor to the kind
+// of failure. Go's SOCKS client reports the Tor specific codes as "unknown
+// code: N", while privoxy describes them in its error page.
+var torReplies = []struct {
+	messages []string
+	kind     string
+}{
+	{[]string{"unknown code: 240", "unknown code: 241", "descriptor can not be found", "descriptor is invalid"}, FailureDescriptorNotFound},
+	{[]string{"unknown code: 242", "unknown code: 243", "unknown code: 247", "introduction failed", "rendezvous failed", "introduction timed out", "host unreachable", "ttl expired"}, FailureUnreachable},
+	{[]string{"unknown code: 244", "unknown code: 245", "client authorization"}, FailureClientAuth},
+	{[]string{"unknown code: 246", "invalid address"}, FailureBadAddress},
+	{[]string{"connection refused"}, FailureConnectionRefused},
+	{[]string{"general socks server failure", "socks5 request failed"}, FailureSocks},
+}
+
+// FailedURL keeps track of the URLs that could not be fetched, in order to
+// retry them and eventually mark them as dead
+type FailedURL struct {
+	gorm.Model
+	URLHash   string `gorm:"size:32;unique_index"`
+	URL       string `gorm:"type:text"`
+	Domain    string `gorm:"index:domain"`
+	Kind      string `gorm:"index:kind"`
+	LastError string `gorm:"type:text"`
+	Attempts  int
+	NextRetry time.Time `gorm:"index:next_retry"`
+	Dead      bool      `gorm:"index:dead"`
+}
+
+// classifyFailure returns the kind of failure of a fetch, looking at the
+// errors of the SOCKS dialer and at the error pages of privoxy. Errors reaching
+// the proxy itself are SOCKS failures.
+func classifyFailure(status int, body []byte, err error) string {
+	msg := ""
+	if err != nil {
+		msg = strings.ToLower(err.Error())
+	}
+	if strings.Contains(msg, "proxyconnect") ||
+		(strings.Contains(msg, "socks connect") && strings.Contains(msg, "dial tcp")) {
+		return FailureSocks
+	}
+	if page := strings.ToLower(string(body)); strings.Contains(page, "privoxy") {
+		msg += " " + page
+	}
+	for _, reply := range torReplies {
+		for _, message := range reply.messages {
+			if strings.Contains(msg, message) {
+				return reply.kind
+			}
+		}
+	}
+	if strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline exceeded") {
+		return FailureTimeout
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return FailureTimeout
+	}
+	switch {
+	case status == http.StatusRequestTimeout:
+		return FailureTimeout
+	case status == http.StatusTooManyRequests:
+		return FailureRateLimited
+	case status >= 500:
+		return FailureServerError
+	case status >= 400:
+		return FailureClientError
+	}
+	return FailureOther
+}
+
+// isPermanentFailure tells whether a kind of failure will not go away by
+// retrying
+func isPermanentFailure(kind string) bool {
+	switch kind {
+	case FailureClientError, FailureClientAuth, FailureBadAddress:
+		return true
+	}
+	return false
+}
+
+// isHostFailure tells whether a kind of failure concerns the whole service
+// rather than a single page
+func isHostFailure(kind string) bool {
+	switch kind {
+	case FailureDescriptorNotFound, FailureUnreachable, FailureConnectionRefused, FailureBadAddress:
+		return true
+	}
+	return false
+}
+
+// recordFailure saves a failed fetch and schedules a retry with exponential
+// backoff, marking the URL as dead after too many attempts
+func (spider *Spider) recordFailure(URL, domain, kind string, fetchErr error) {
+	var failed FailedURL
+	if spider.rdbms.Where("url_hash = ?", strToMD5(URL)).First(&failed).RecordNotFound() {
+		failed = FailedURL{
+			URLHash: strToMD5(URL),
+			URL:     URL,
+			Domain:  domain,
+		}
+	}
+	failed.Attempts++
+	failed.Kind = kind
+	if fetchErr != nil {
+		failed.LastError = fetchErr.Error()
+	}
+
+	if isPermanentFailure(kind) || failed.Attempts >= spider.maxRetries {
+		failed.Dead = true
+		spider.Logger.Infof("Marking %s as dead after %d attempts (%s)", URL, failed.Attempts, kind)
+		if isHostFailure(kind) {
+			spider.markUnhealthy(URL)
+		}
+	} else {
+		failed.NextRetry = time.Now().Add(spider.retryDelay << uint(failed.Attempts-1))
+	}
+
+	if err := spider.rdbms.Save(&failed).Error; err != nil {
+		spider.Logger.Error(err)
+	}
+}
+
+// clearFailure forgets the failures of a URL once it has been fetched
+func (spider *Spider) clearFailure(URL string) {
+	err := spider.rdbms.Unscoped().Where("url_hash = ?", strToMD5(URL)).Delete(&FailedURL{}).Error
+	if err != nil {
+		spider.Logger.Error(err)
+	}
+}
+
+// markUnhealthy marks as unhealthy the oniontree URLs of the service hosting
+// rawURL
+func (spider *Spider) markUnhealthy(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return
+	}
+	err = spider.rdbms.Model(&URL{}).
+		Where("name LIKE ?", "%"+u.Hostname()+"%").
+		Update("healthy", false).Error
+	if err != nil {
+		spider.Logger.Error(err)
+	}
+}
+
+// startRetries periodically enqueues the failed URLs that are due for a retry
+func (spider *Spider) startRetries() {
+	spider.feeders.Add(1)
+	go func() {
+		defer spider.feeders.Done()
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-spider.quit:
+				return
+			case <-ticker.C:
+				spider.scheduleRetries()
+			}
+		}
+	}()
+}
+
+func (spider *Spider) scheduleRetries() {
+	var failures []FailedURL
+	err := spider.rdbms.
+		Where("dead = ? AND next_retry <= ?", false, time.Now()).
+		Order("next_retry").
+		Limit(cap(spider.jobs) / 10).
+		Find(&failures).Error
+	if err != nil {
+		spider.Logger.Error(err)
+		return
+	}
+
+	for _, failed := range failures {
+		job, err := spider.newJob(failed.URL, "", 0)
+		if err != nil {
+			spider.Logger.Error(err)
+			continue
+		}
+		job.Attempts = failed.Attempts
+		// push the retry forward so that the URL is not enqueued again while it
+		// waits to be crawled
+		failed.NextRetry = time.Now().Add(spider.retryDelay << uint(failed.Attempts))
+		if err := spider.rdbms.Save(&failed).Error; err != nil {
+			spider.Logger.Error(err)
+			continue
+		}
+		spider.enqueue(job)
+	}
+
+	if len(failures) > 0 {
+		spider.Logger.Infof("Scheduled %d retries", len(failures))
+	}
+}
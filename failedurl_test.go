@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	socks := func(reply string) error {
+		return fmt.Errorf(`Get "http://x.onion/": socks connect tcp 127.0.0.1:9050->x.onion:80: unknown error %s`, reply)
+	}
+	privoxy := func(reason string) []byte {
+		return []byte("<h2>Privoxy was unable to socks5t-forward your request http://x.onion/ through 127.0.0.1:9050: " + reason + "</h2>")
+	}
+	tests := []struct {
+		status int
+		body   []byte
+		err    error
+		want   string
+	}{
+		{0, nil, socks("unknown code: 240"), FailureDescriptorNotFound},
+		{0, nil, socks("unknown code: 241"), FailureDescriptorNotFound},
+		{0, nil, socks("unknown code: 242"), FailureUnreachable},
+		{0, nil, socks("unknown code: 247"), FailureUnreachable},
+		{0, nil, socks("unknown code: 245"), FailureClientAuth},
+		{0, nil, socks("unknown code: 246"), FailureBadAddress},
+		{0, nil, socks("host unreachable"), FailureUnreachable},
+		{0, nil, socks("TTL expired"), FailureUnreachable},
+		{0, nil, socks("connection refused"), FailureConnectionRefused},
+		{0, nil, socks("general SOCKS server failure"), FailureSocks},
+		{0, nil, errors.New(`Get "http://x.onion/": socks connect tcp 127.0.0.1:9050->x.onion:80: dial tcp 127.0.0.1:9050: connect: connection refused`), FailureSocks},
+		{0, nil, errors.New(`Get "http://x.onion/": proxyconnect tcp: dial tcp 127.0.0.1:8118: connect: connection refused`), FailureSocks},
+		{503, privoxy("Tor onion service descriptor can not be found"), errors.New("Service Unavailable"), FailureDescriptorNotFound},
+		{503, privoxy("Tor onion service rendezvous failed"), errors.New("Service Unavailable"), FailureUnreachable},
+		{503, privoxy("SOCKS5 request failed"), errors.New("Service Unavailable"), FailureSocks},
+		{503, []byte("descriptor can not be found"), errors.New("Service Unavailable"), FailureServerError},
+		{0, nil, fmt.Errorf("Get %q: %w", "http://x.onion/", context.DeadlineExceeded), FailureTimeout},
+		{408, nil, errors.New("Request Timeout"), FailureTimeout},
+		{429, nil, errors.New("Too Many Requests"), FailureRateLimited},
+		{404, nil, errors.New("Not Found"), FailureClientError},
+		{500, nil, errors.New("Internal Server Error"), FailureServerError},
+		{0, nil, errors.New("EOF"), FailureOther},
+	}
+	for _, test := range tests {
+		if got := classifyFailure(test.status, test.body, test.err); got != test.want {
+			t.Errorf("classifyFailure(%d, %q, %v) should be %q, got %q", test.status, test.body, test.err, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// pageRecord is a page as written to the file and stream storages
+type pageRecord struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	PageInfo
+	PageProperties PageProperties `json:"page_properties,omitempty"`
+}
+
+func newPageRecord(page PageInfo) pageRecord {
+	return pageRecord{
+		ID:             page.ID,
+		CreatedAt:      page.CreatedAt,
+		UpdatedAt:      page.UpdatedAt,
+		PageInfo:       page,
+		PageProperties: page.PageProperties,
+	}
+}
+
+// FilePageStorage is an implementation of the PageStorage interface which
+// appends the pages as JSON lines to a file of Dir per day
+type FilePageStorage struct {
+	Dir string
+
+	file *os.File
+	day  string
+	mu   sync.Mutex
+}
+
+// Init creates the directory of the files
+func (f *FilePageStorage) Init() error {
+	return os.MkdirAll(f.Dir, 0755)
+}
+
+// SavePage appends a page to the file of the day
+func (f *FilePageStorage) SavePage(page PageInfo) error {
+	data, err := json.Marshal(newPageRecord(page))
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if day := time.Now().Format("2006-01-02"); f.file == nil || day != f.day {
+		if err := f.close(); err != nil {
+			return err
+		}
+		path := filepath.Join(f.Dir, "pages-"+day+".jsonl")
+		f.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		f.day = day
+	}
+	_, err = f.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the current file
+func (f *FilePageStorage) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.close()
+}
+
+func (f *FilePageStorage) close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -13,7 +13,7 @@ require (
 	github.com/disintegration/imaging v1.6.2 // indirect
 	github.com/elastic/go-elasticsearch/v8 v8.0.0-20200514114228-c61e61962819
 	github.com/gin-gonic/gin v1.6.3 // indirect
-	github.com/go-redis/redis v6.15.7+incompatible // indirect
+	github.com/go-redis/redis v6.15.7+incompatible
 	github.com/gobwas/glob v0.2.3 // indirect
 	github.com/goccy/go-yaml v1.4.6 // indirect
 	github.com/gocolly/colly v1.2.0
@@ -47,13 +47,15 @@ require (
 	github.com/saintfish/chardet v0.0.0-20120816061221-3af4cd4741ca // indirect
 	github.com/sirupsen/logrus v1.4.2
 	github.com/ssor/bom v0.0.0-20170718123548-6386211fdfcf // indirect
-	github.com/temoto/robotstxt v1.1.1 // indirect
+	github.com/temoto/robotstxt v1.1.1
 	github.com/theplant/cldr v0.0.0-20190423050709-9f76f7ce4ee8 // indirect
 	github.com/tsak/concurrent-csv-writer v0.0.0-20200206204244-84054e222625 // indirect
 	github.com/urandom/text-summary v0.0.0-20150104142726-3e2dd4c46c53 // indirect
 	github.com/velebak/colly-sqlite3-storage v0.0.0-20190425160637-c76683d5163d
 	github.com/withmandala/go-log v0.1.0
 	go.mongodb.org/mongo-driver v1.3.3
+	golang.org/x/crypto v0.0.0-20191205180655-e7c4368fe9dd
+	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
 	golang.org/x/net v0.0.0-20200421231249-e086a090c8fd
 	gonum.org/v1/gonum v0.7.0 // indirect
 	google.golang.org/appengine v1.6.6 // indirect
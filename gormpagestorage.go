@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// GormPageStorage is an implementation of the PageStorage interface which
+// copies the pages to another database, like an archive or a replica used for
+// analytics. Pages are upserted by URL, without their associations.
+type GormPageStorage struct {
+	Dialect string
+	DSN     string
+	db      *gorm.DB
+}
+
+// Init connects to the database and migrates the pages table
+func (g *GormPageStorage) Init() error {
+	var err error
+	g.db, err = gorm.Open(g.Dialect, g.DSN)
+	if err != nil {
+		return err
+	}
+	g.db = g.db.Set("gorm:save_associations", false)
+	return g.db.AutoMigrate(&PageInfo{}).Error
+}
+
+// SavePage inserts or updates the copy of a page
+func (g *GormPageStorage) SavePage(page PageInfo) error {
+	var existing PageInfo
+	err := g.db.Select("id, created_at").Where("url = ?", page.URL).First(&existing).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	page.ID = existing.ID
+	if existing.ID != 0 {
+		page.CreatedAt = existing.CreatedAt
+	}
+	page.PageTopic, page.PageAttributes, page.Versions = nil, nil, nil
+	return g.db.Save(&page).Error
+}
+
+// Close closes the connection to the database
+func (g *GormPageStorage) Close() error {
+	return g.db.Close()
+}
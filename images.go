@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"github.com/samirettali/tor-spider/pkg/imagehash"
+)
+
+// maxImageSize is the maximum size of a fetched image
+const maxImageSize = 1 << 20
+
+// maxLogos is the maximum number of logos hashed per home page
+const maxLogos = 3
+
+// Kinds of page images
+const (
+	ImageFavicon = "favicon"
+	ImageLogo    = "logo"
+)
+
+// PageImage holds the hashes of the favicon or of a logo of a page
+type PageImage struct {
+	gorm.Model
+	URLHash   string `gorm:"size:32;unique_index:page_image"`
+	URL       string `gorm:"type:text"`
+	Domain    string `gorm:"index:domain"`
+	ImageHash string `gorm:"size:32;unique_index:page_image"`
+	ImageURL  string `gorm:"type:text"`
+	Kind      string `gorm:"size:16;index:kind"`
+	MD5       string `gorm:"column:md5;size:32;index:md5"`
+	MMH3      int32  `gorm:"column:mmh3;index:mmh3"`
+	AHash     uint64 `gorm:"column:a_hash"`
+	DHash     uint64 `gorm:"column:d_hash"`
+	PHash     uint64 `gorm:"column:p_hash"`
+}
+
+// pageImages returns the URLs of the favicon and of the logos of a page
+func pageImages(pageURL *url.URL, dom *goquery.Document) map[string]string {
+	images := make(map[string]string)
+	favicon := "/favicon.ico"
+	dom.Find("link[rel][href]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		rel, _ := s.Attr("rel")
+		for _, field := range strings.Fields(strings.ToLower(rel)) {
+			// an empty href would resolve to the page itself
+			if href, _ := s.Attr("href"); field == "icon" && strings.TrimSpace(href) != "" {
+				favicon = strings.TrimSpace(href)
+				return false
+			}
+		}
+		return true
+	})
+	if u, err := pageURL.Parse(favicon); err == nil {
+		images[u.String()] = ImageFavicon
+	}
+
+	logos := 0
+	dom.Find("img[src]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		src, _ := s.Attr("src")
+		alt, _ := s.Attr("alt")
+		class, _ := s.Attr("class")
+		id, _ := s.Attr("id")
+		if strings.TrimSpace(src) == "" || !strings.Contains(strings.ToLower(src+" "+alt+" "+class+" "+id), "logo") {
+			return true
+		}
+		if u, err := pageURL.Parse(strings.TrimSpace(src)); err == nil {
+			if _, ok := images[u.String()]; !ok {
+				images[u.String()] = ImageLogo
+				logos++
+			}
+		}
+		return logos < maxLogos
+	})
+	return images
+}
+
+// decodeDataURI returns the content of a base64 data URI
+func decodeDataURI(uri string) ([]byte, error) {
+	comma := strings.IndexByte(uri, ',')
+	if comma < 0 || !strings.HasSuffix(uri[:comma], ";base64") {
+		return nil, errors.New("unsupported data uri")
+	}
+	return base64.StdEncoding.DecodeString(uri[comma+1:])
+}
+
+// fetchImage downloads an image through the proxies
+func (spider *Spider) fetchImage(imageURL string) ([]byte, error) {
+	if strings.HasPrefix(imageURL, "data:") {
+		return decodeDataURI(imageURL)
+	}
+	resp, err := spider.client.Get(imageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("got %d for %s", resp.StatusCode, imageURL)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", imageURL, maxImageSize)
+	}
+	return data, nil
+}
+
+// savePageImages hashes the favicon and the logos of a page
+func (spider *Spider) savePageImages(pageURL *url.URL, domain string, dom *goquery.Document) {
+	for imageURL, kind := range pageImages(pageURL, dom) {
+		data, err := spider.fetchImage(imageURL)
+		if err != nil {
+			spider.Logger.Debugf("Could not fetch %s: %v", imageURL, err)
+			continue
+		}
+		if len(data) == 0 {
+			continue
+		}
+		image := PageImage{
+			URL:      pageURL.String(),
+			Domain:   domain,
+			ImageURL: imageURL,
+			Kind:     kind,
+			MD5:      imagehash.MD5(data),
+			MMH3:     imagehash.MMH3(data),
+		}
+		if img, err := imagehash.Decode(data); err == nil {
+			image.AHash = imagehash.AHash(img)
+			image.DHash = imagehash.DHash(img)
+			image.PHash = imagehash.PHash(img)
+		} else {
+			spider.Logger.Debugf("Could not decode %s: %v", imageURL, err)
+		}
+
+		var saved PageImage
+		err = spider.rdbms.
+			Where(PageImage{URLHash: strToMD5(image.URL), ImageHash: strToMD5(imageURL)}).
+			Assign(image).
+			FirstOrCreate(&saved).Error
+		if err != nil {
+			spider.Logger.Error(err)
+		}
+	}
+}
+
+// faviconHandler serves the pages whose favicon has the MD5 or mmh3 hash
+// param
+func (spider *Spider) faviconHandler(c *gin.Context) {
+	hash := c.Param("hash")
+	query := spider.rdbms.Where("kind = ?", ImageFavicon)
+	if mmh3, err := strconv.ParseInt(hash, 10, 32); err == nil {
+		query = query.Where("mmh3 = ?", mmh3)
+	} else {
+		query = query.Where("md5 = ?", strings.ToLower(hash))
+	}
+	var images []PageImage
+	if err := query.Find(&images).Error; err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.JSON(200, images)
+}
+
+// similarImagesHandler serves the images whose pHash is within the distance
+// param, 10 by default, of the image of the id param
+func (spider *Spider) similarImagesHandler(c *gin.Context) {
+	distance := 10
+	if d, err := strconv.Atoi(c.Query("distance")); err == nil {
+		distance = d
+	}
+	var image PageImage
+	if spider.rdbms.First(&image, c.Param("id")).RecordNotFound() {
+		c.String(404, "image not found")
+		return
+	}
+	if image.PHash == 0 {
+		c.String(422, "image could not be decoded")
+		return
+	}
+
+	var candidates []PageImage
+	err := spider.rdbms.Select("id, p_hash").Where("p_hash <> 0 AND id <> ?", image.ID).Find(&candidates).Error
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	var ids []uint
+	for _, candidate := range candidates {
+		if imagehash.Distance(image.PHash, candidate.PHash) <= distance {
+			ids = append(ids, candidate.ID)
+		}
+	}
+	similar := []PageImage{}
+	if len(ids) > 0 {
+		if err := spider.rdbms.Where("id IN (?)", ids).Find(&similar).Error; err != nil {
+			c.String(500, err.Error())
+			return
+		}
+	}
+	c.JSON(200, similar)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestPageImages(t *testing.T) {
+	pageURL, _ := url.Parse("http://example.onion/shop/")
+	tests := []struct {
+		html string
+		want map[string]string
+	}{
+		{`<link rel="icon" href="/static/icon.png">`, map[string]string{"http://example.onion/static/icon.png": ImageFavicon}},
+		{`<link rel="icon" href="">`, map[string]string{"http://example.onion/favicon.ico": ImageFavicon}},
+		{`<link rel="shortcut icon" href="  "><link rel="icon" href="icon.png">`, map[string]string{"http://example.onion/shop/icon.png": ImageFavicon}},
+		{`<img src="" class="logo">`, map[string]string{"http://example.onion/favicon.ico": ImageFavicon}},
+	}
+	for _, test := range tests {
+		dom, err := goquery.NewDocumentFromReader(strings.NewReader(test.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pageImages(pageURL, dom); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("pageImages(%q) should be %v, got %v", test.html, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/jinzhu/gorm"
+
+	"github.com/samirettali/tor-spider/pkg/graph"
+)
+
+// PageLink is an edge of the link graph between two pages
+type PageLink struct {
+	gorm.Model
+	FromHash string `gorm:"size:32;index:from_hash"`
+	ToHash   string `gorm:"size:32;index:to_hash"`
+	FromURL  string `gorm:"type:text"`
+	ToURL    string `gorm:"type:text"`
+	FromHost string `gorm:"index:from_host"`
+	ToHost   string `gorm:"index:to_host"`
+	Anchor   string `gorm:"type:text; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"`
+	Count    int
+}
+
+// DomainLink is an edge of the link graph between two hosts, aggregated from
+// the links between their pages
+type DomainLink struct {
+	gorm.Model
+	FromHost string `gorm:"index:from_host"`
+	ToHost   string `gorm:"index:to_host"`
+	Pages    int
+	Count    int
+}
+
+// extractLinks returns the outbound links of a page, keyed by their canonical
+// URL
+func (spider *Spider) extractLinks(pageURL *url.URL, dom *goquery.Document) map[string]*PageLink {
+	links := make(map[string]*PageLink)
+	dom.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		target, err := pageURL.Parse(strings.TrimSpace(href))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+			return
+		}
+		canonical, err := spider.canonicalizer.Canonicalize(target.String())
+		if err != nil {
+			return
+		}
+		link, ok := links[canonical]
+		if !ok {
+			u, _ := url.Parse(canonical)
+			link = &PageLink{
+				ToHash: strToMD5(canonical),
+				ToURL:  canonical,
+				ToHost: u.Hostname(),
+			}
+			links[canonical] = link
+		}
+		link.Count++
+		if link.Anchor == "" {
+			link.Anchor = strings.Join(strings.Fields(s.Text()), " ")
+		}
+	})
+	return links
+}
+
+// addOnionReference adds to links a reference to an onion service mentioned
+// in the page, unless the page already links to it
+func addOnionReference(links map[string]*PageLink, onion string) {
+	for _, link := range links {
+		if link.ToHost == onion {
+			return
+		}
+	}
+	target := "http://" + onion + "/"
+	links[target] = &PageLink{
+		ToHash: strToMD5(target),
+		ToURL:  target,
+		ToHost: onion,
+		Count:  1,
+	}
+}
+
+// saveLinks replaces the outbound links of a page in the edge store
+func (spider *Spider) saveLinks(pageURL string, links map[string]*PageLink) error {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return err
+	}
+	fromHash := strToMD5(pageURL)
+
+	tx := spider.rdbms.Begin()
+	if err := tx.Unscoped().Where("from_hash = ?", fromHash).Delete(&PageLink{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, link := range links {
+		link.FromHash = fromHash
+		link.FromURL = pageURL
+		link.FromHost = u.Hostname()
+		if err := tx.Create(link).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// rebuildDomainLinks aggregates the page links into host to host edges
+func (spider *Spider) rebuildDomainLinks() error {
+	tx := spider.rdbms.Begin()
+	if err := tx.Unscoped().Delete(&DomainLink{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	err := tx.Exec(`INSERT INTO domain_links (created_at, updated_at, from_host, to_host, pages, count)
+		SELECT NOW(), NOW(), from_host, to_host, COUNT(DISTINCT from_hash), SUM(count)
+		FROM page_links WHERE deleted_at IS NULL AND from_host <> to_host
+		GROUP BY from_host, to_host`).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// linkGraph loads the page or domain level link graph
+func (spider *Spider) linkGraph(level string) (*graph.Graph, error) {
+	g := graph.New()
+	switch level {
+	case "page":
+		rows, err := spider.rdbms.Model(&PageLink{}).Select("from_url, to_url, count, anchor").Rows()
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var from, to, anchor string
+			var count int
+			if err := rows.Scan(&from, &to, &count, &anchor); err != nil {
+				return nil, err
+			}
+			g.AddEdge(from, to, count, anchor)
+		}
+		return g, rows.Err()
+	case "domain":
+		if err := spider.rebuildDomainLinks(); err != nil {
+			return nil, err
+		}
+		rows, err := spider.rdbms.Model(&DomainLink{}).Select("from_host, to_host, count").Rows()
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var from, to string
+			var count int
+			if err := rows.Scan(&from, &to, &count); err != nil {
+				return nil, err
+			}
+			g.AddEdge(from, to, count, "")
+		}
+		return g, rows.Err()
+	}
+	return nil, fmt.Errorf("unknown graph level %q", level)
+}
+
+// exportGraph writes the link graph to path, in the GraphML, GEXF or CSV
+// format according to its extension
+func (spider *Spider) exportGraph(path, level string) error {
+	g, err := spider.linkGraph(level)
+	if err != nil {
+		return err
+	}
+
+	var write func(*graph.Graph, *os.File) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".graphml":
+		write = func(g *graph.Graph, f *os.File) error { return g.WriteGraphML(f) }
+	case ".gexf":
+		write = func(g *graph.Graph, f *os.File) error { return g.WriteGEXF(f) }
+	case ".csv":
+		write = func(g *graph.Graph, f *os.File) error { return g.WriteCSV(f) }
+	default:
+		return fmt.Errorf("unknown graph format %q", filepath.Ext(path))
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(g, f); err != nil {
+		f.Close()
+		return err
+	}
+	spider.Logger.Infof("Exported %d nodes and %d edges to %s", len(g.Nodes()), len(g.Edges), path)
+	return f.Close()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+
+	"github.com/jinzhu/gorm"
+)
+
+// rankBoost is the highest priority boost given to the jobs of the best linked
+// hosts
+const rankBoost = 10
+
+// LinkScore is a struct used to save the PageRank and HITS scores of a page or
+// of a host of the link graph
+type LinkScore struct {
+	gorm.Model
+	Level     string `gorm:"size:16;index:level_name"`
+	NameHash  string `gorm:"size:32;index:level_name"`
+	Name      string `gorm:"type:text"`
+	PageRank  float64
+	Hub       float64
+	Authority float64
+}
+
+// computeScores computes the PageRank and HITS scores of the domain and page
+// link graphs, saves them and pushes the page ones to manticore
+func (spider *Spider) computeScores() error {
+	for _, level := range []string{"domain", "page"} {
+		g, err := spider.linkGraph(level)
+		if err != nil {
+			return err
+		}
+		ranks := g.PageRank(0.85, 100)
+		hubs, authorities := g.HITS(100)
+
+		tx := spider.rdbms.Begin()
+		if err := tx.Unscoped().Where("level = ?", level).Delete(&LinkScore{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		for _, name := range g.Nodes() {
+			score := &LinkScore{
+				Level:     level,
+				NameHash:  strToMD5(name),
+				Name:      name,
+				PageRank:  ranks[name],
+				Hub:       hubs[name],
+				Authority: authorities[name],
+			}
+			if err := tx.Create(score).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+		spider.Logger.Infof("Computed %s scores of %d nodes", level, len(g.Nodes()))
+	}
+	return spider.pushScores()
+}
+
+// pushScores updates the scores attributes of the pages in the manticore index
+func (spider *Spider) pushScores() error {
+	domainRanks, err := spider.loadHostRanks()
+	if err != nil {
+		return err
+	}
+
+	rows, err := spider.rdbms.Table("page_infos").
+		Select("page_infos.id, page_infos.url, link_scores.page_rank, link_scores.hub, link_scores.authority").
+		Joins("JOIN link_scores ON link_scores.level = 'page' AND link_scores.name_hash = MD5(page_infos.url)").
+		Where("page_infos.deleted_at IS NULL").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	updated := 0
+	for rows.Next() {
+		var id uint
+		var pageURL string
+		var pageRank, hub, authority float64
+		if err := rows.Scan(&id, &pageURL, &pageRank, &hub, &authority); err != nil {
+			return err
+		}
+		u, err := url.Parse(pageURL)
+		if err != nil {
+			continue
+		}
+		request, err := json.Marshal(map[string]interface{}{
+			"index": "rt_tor_spider",
+			"id":    id,
+			"doc": map[string]float64{
+				"pagerank":    pageRank,
+				"hub":         hub,
+				"authority":   authority,
+				"domain_rank": domainRanks[u.Hostname()],
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := spider.manticore.Json("json/update", string(request)); err != nil {
+			spider.Logger.Warnln("manticore update", id, err)
+			continue
+		}
+		updated++
+	}
+	spider.Logger.Infof("Pushed the scores of %d pages to manticore", updated)
+	return rows.Err()
+}
+
+// loadHostRanks returns the PageRank of every host, scaled so that the best
+// linked host has a score of one
+func (spider *Spider) loadHostRanks() (map[string]float64, error) {
+	var scores []LinkScore
+	if err := spider.rdbms.Where("level = ?", "domain").Find(&scores).Error; err != nil {
+		return nil, err
+	}
+	max := 0.0
+	for _, score := range scores {
+		if score.PageRank > max {
+			max = score.PageRank
+		}
+	}
+	ranks := make(map[string]float64, len(scores))
+	for _, score := range scores {
+		if max > 0 {
+			ranks[score.Name] = score.PageRank / max
+		}
+	}
+	return ranks, nil
+}
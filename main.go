@@ -2,11 +2,15 @@ package main
 
 import (
 	"bufio"
-	"database/sql"
+	"context"
 	"flag"
 	"fmt"
 	"os"
-	"regexp"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gocolly/redisstorage"
@@ -20,8 +24,14 @@ import (
 	log "github.com/sirupsen/logrus"
 	ccsv "github.com/tsak/concurrent-csv-writer"
 
+	"github.com/samirettali/tor-spider/pkg/extract"
 	"github.com/samirettali/tor-spider/pkg/gowap"
+	"github.com/samirettali/tor-spider/pkg/hostlimit"
 	"github.com/samirettali/tor-spider/pkg/manticore"
+	"github.com/samirettali/tor-spider/pkg/proxypool"
+	"github.com/samirettali/tor-spider/pkg/simhash"
+	"github.com/samirettali/tor-spider/pkg/urlnorm"
+	"github.com/samirettali/tor-spider/pkg/warc"
 )
 
 func main() {
@@ -37,6 +47,34 @@ func main() {
 	// isAdmin := flag.Bool("a", false, "start webui admin")
 	indexManticore := flag.Bool("i", false, "index to manticore")
 	searchManticore := flag.String("s", "", "search manticore index")
+	shutdownTimeout := flag.Duration("t", 30*time.Second, "time to wait for collectors on shutdown")
+	revisitWindow := flag.Duration("r", 24*time.Hour, "window in which an url is enqueued only once")
+	dropParams := flag.String("q", "", "comma separated query params to drop from urls")
+	minRevisit := flag.Duration("m", 6*time.Hour, "minimum interval between two visits of a page")
+	maxRevisit := flag.Duration("M", 30*24*time.Hour, "maximum interval between two visits of a page")
+	maxRetries := flag.Int("R", 5, "attempts before marking a failed url as dead")
+	retryDelay := flag.Duration("D", 5*time.Minute, "delay before the first retry of a failed url")
+	monitor := flag.Bool("l", false, "monitor the liveness of the onion services")
+	monitorInterval := flag.Duration("L", 15*time.Minute, "interval between two liveness probes")
+	exportGraph := flag.String("g", "", "export the link graph to a .graphml, .gexf or .csv file")
+	graphLevel := flag.String("G", "domain", "level of the exported link graph, domain or page")
+	computeScores := flag.Bool("k", false, "compute PageRank and HITS scores of the link graph")
+	rankPriority := flag.Bool("P", false, "crawl first the jobs of the best linked hosts")
+	pinProxies := flag.Bool("n", false, "always use the same proxy for the same host")
+	isolateProxies := flag.Bool("I", false, "use distinct SOCKS credentials for each host (IsolateSOCKSAuth)")
+	hostConcurrency := flag.Int("c", 2, "maximum concurrent requests to a host")
+	hostDelay := flag.Duration("y", time.Second, "minimum delay between two requests to a host")
+	hostRulesFile := flag.String("H", "", "file of per host pattern rules: pattern concurrency delay [maxdelay]")
+	obeyRobots := flag.Bool("T", false, "honour robots.txt and its crawl delay")
+	sitemaps := flag.Bool("S", false, "seed jobs from the sitemaps of the crawled hosts")
+	hashImages := flag.Bool("z", true, "hash the favicon and the logos of the home pages")
+	mirrorInterval := flag.Duration("j", time.Hour, "interval between the detections of mirrors and phishing clones, 0 to disable")
+	nearDistance := flag.Int("N", 3, "maximum simhash distance between near duplicate pages")
+	pivotEntity := flag.String("E", "", "print the domains and services sharing an entity given as type:value")
+	warcDir := flag.String("W", "", "archive the raw responses to WARC files in this directory")
+	warcSize := flag.Int64("Z", 1024, "size in MB of the WARC files before starting a new one")
+	replayWARC := flag.String("Y", "", "process the responses of the WARC files matching this glob, without crawling")
+	extractors := flag.String("e", "", "comma separated extractors to run, all by default: "+strings.Join(extract.Names(), ","))
 
 	flag.Parse()
 
@@ -71,6 +109,22 @@ func main() {
 	db.AutoMigrate(&Service{})
 	db.AutoMigrate(&URL{})
 	db.AutoMigrate(&PublicKey{})
+	db.AutoMigrate(&PageVersion{})
+	db.AutoMigrate(&PageSchedule{})
+	db.AutoMigrate(&FailedURL{})
+	db.AutoMigrate(&UptimeCheck{})
+	db.AutoMigrate(&PageLink{})
+	db.AutoMigrate(&DomainLink{})
+	db.AutoMigrate(&LinkScore{})
+	db.AutoMigrate(&PagePublicKey{})
+	db.AutoMigrate(&Entity{})
+	db.AutoMigrate(&EntityOccurrence{})
+	db.AutoMigrate(&MirrorCluster{})
+	db.AutoMigrate(&MirrorDomain{})
+	db.AutoMigrate(&PageImage{})
+	if err := migratePublicKeys(db); err != nil {
+		log.Fatal(err)
+	}
 
 	if *fixDomain {
 		var pages []PageInfo
@@ -86,6 +140,31 @@ func main() {
 		}
 	}
 
+	if *pivotEntity != "" {
+		entityType, value, err := parseEntity(*pivotEntity)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pivoter := &Spider{rdbms: db, Logger: logger}
+		pivot, err := pivoter.pivot(entityType, value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if pivot == nil {
+			log.Fatalf("Entity %s not found", *pivotEntity)
+		}
+		pp.Println(pivot)
+		return
+	}
+
+	if *exportGraph != "" {
+		exporter := &Spider{rdbms: db, Logger: logger}
+		if err := exporter.exportGraph(*exportGraph, *graphLevel); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if *dumpUrls {
 		csvDataset, err := ccsv.NewCsvWriter("urls.txt")
 		if err != nil {
@@ -112,6 +191,19 @@ func main() {
 	cl, _, err := initSphinx("127.0.0.1", 9312)
 	checkErr(err)
 
+	if *computeScores {
+		scorer := &Spider{
+			rdbms:         db,
+			manticore:     cl,
+			canonicalizer: urlnorm.New(strings.Split(*dropParams, ",")...),
+			Logger:        logger,
+		}
+		if err := scorer.computeScores(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if *searchManticore != "" {
 		fmt.Println("query:", *searchManticore)
 		res2, err2 := cl.Query(*searchManticore, "rt_tor_spider")
@@ -123,44 +215,21 @@ func main() {
 	}
 
 	if *indexManticore {
-
-		cl, err := sql.Open("mysql", "@tcp(127.0.0.1:9306)/")
-		if err != nil {
-			panic(err)
+		index := &ManticorePageStorage{
+			Host:   "127.0.0.1",
+			Port:   9312,
+			Logger: logger,
 		}
-
-		var pageInfos []*PageInfo
+		checkErr(index.Init())
+		var pageInfos []PageInfo
 		db.Where("status = ?", "200").Find(&pageInfos)
 		for _, pageInfo := range pageInfos {
-			var deletedAt time.Time
-			if pageInfo.DeletedAt == nil {
-				deletedAt = time.Date(2001, time.January, 01, 01, 0, 0, 0, time.UTC)
-			} else {
-				deletedAt = *pageInfo.DeletedAt
-			}
-
-			query := fmt.Sprintf(`REPLACE into rt_tor_spider (id,created_at,updated_at,deleted_at,url,summary,title,is_home_page,status,language,domain,category,wapp,page_properties) VALUES ('%d','%d','%d','%d','%s','%s','%s','%t','%d','%s','%s','%s','%s','%s')`,
-				pageInfo.ID,
-				pageInfo.CreatedAt.Unix(),
-				pageInfo.UpdatedAt.Unix(),
-				deletedAt.Unix(),
-				escape(pageInfo.URL),
-				escape(pageInfo.Summary),
-				escape(pageInfo.Title),
-				pageInfo.IsHomePage,
-				pageInfo.Status,
-				pageInfo.Language,
-				pageInfo.Domain,
-				pageInfo.Category,
-				pageInfo.Wapp,
-				pageInfo.PageProperties,
-			)
-			//fmt.Println(query)
-			_, err := cl.Exec(query)
-			if err != nil {
+			if err := index.SavePage(pageInfo); err != nil {
 				log.Warnln("index err", err)
 			}
-			// pp.Println(res)
+		}
+		if err := index.Close(); err != nil {
+			log.Warnln("index err", err)
 		}
 		os.Exit(1)
 	}
@@ -179,23 +248,99 @@ func main() {
 		Password: "",
 		DB:       0,
 		Prefix:   "0",
+		// visited pages can be crawled again by the revisit scheduler
+		Expires: *minRevisit,
 	}
 	// defer visitedStorage.Client.Close()
 
-	// Elastic for page saving
-	elasticURI, ok := os.LookupEnv("ELASTIC_URI")
-	if !ok {
-		logger.Error("You must set ELASTIC_URI env variable")
+	// Redis for urls already enqueued
+	seenFilter := &RedisSeenFilter{
+		Address: redisURI,
+		Prefix:  "0",
+		Window:  *revisitWindow,
 	}
-	elasticIndex, ok := os.LookupEnv("ELASTIC_INDEX")
-	if !ok {
-		logger.Error("You must set ELASTIC_INDEX env variable")
+
+	canonicalizer := urlnorm.New(strings.Split(*dropParams, ",")...)
+
+	// Comma separated list of the storages the pages are saved to, among
+	// elastic, manticore, mysql, file and redis
+	storageNames := "elastic,manticore"
+	if names, ok := os.LookupEnv("PAGE_STORAGES"); ok {
+		storageNames = names
 	}
-	pageStorage := &ElasticPageStorage{
-		URI:        elasticURI,
-		Index:      elasticIndex,
-		BufferSize: 100,
-		Logger:     logger,
+	pageStorage := &MultiPageStorage{Logger: logger}
+	for _, name := range strings.Split(storageNames, ",") {
+		var storage PageStorage
+		switch name = strings.TrimSpace(name); name {
+		case "elastic":
+			// Elastic for page saving
+			elasticURI, ok := os.LookupEnv("ELASTIC_URI")
+			if !ok {
+				logger.Error("You must set ELASTIC_URI env variable")
+			}
+			elasticIndex, ok := os.LookupEnv("ELASTIC_INDEX")
+			if !ok {
+				logger.Error("You must set ELASTIC_INDEX env variable")
+			}
+			// failed documents are logged, and dumped to this file if it is set
+			elasticDeadLetter, _ := os.LookupEnv("ELASTIC_DEAD_LETTER")
+			// the index is a rollover alias if any rollover condition is set
+			var elasticRolloverAge time.Duration
+			if age, ok := os.LookupEnv("ELASTIC_ROLLOVER_AGE"); ok {
+				elasticRolloverAge, err = time.ParseDuration(age)
+				checkErr(err)
+			}
+			var elasticRolloverDocs int
+			if docs, ok := os.LookupEnv("ELASTIC_ROLLOVER_DOCS"); ok {
+				elasticRolloverDocs, err = strconv.Atoi(docs)
+				checkErr(err)
+			}
+			storage = &ElasticPageStorage{
+				URI:           elasticURI,
+				Index:         elasticIndex,
+				BufferSize:    100,
+				FlushInterval: 30 * time.Second,
+				DeadLetter:    elasticDeadLetter,
+				Rollover:      elasticRolloverAge > 0 || elasticRolloverDocs > 0,
+				RolloverAge:   elasticRolloverAge,
+				RolloverDocs:  elasticRolloverDocs,
+				Canonicalizer: canonicalizer,
+				Logger:        logger,
+			}
+		case "manticore":
+			storage = &ManticorePageStorage{
+				Host:          "127.0.0.1",
+				Port:          9312,
+				FlushInterval: 30 * time.Second,
+				Logger:        logger,
+			}
+		case "mysql":
+			// a copy of the pages in another database
+			dsn, ok := os.LookupEnv("PAGE_STORAGE_DSN")
+			if !ok {
+				log.Fatal("You must set PAGE_STORAGE_DSN env variable")
+			}
+			storage = &GormPageStorage{Dialect: "mysql", DSN: dsn}
+		case "file":
+			dir, ok := os.LookupEnv("PAGE_STORAGE_DIR")
+			if !ok {
+				dir = "./shared/pages"
+			}
+			storage = &FilePageStorage{Dir: dir}
+		case "redis":
+			stream, ok := os.LookupEnv("PAGE_STORAGE_STREAM")
+			if !ok {
+				stream = "pages"
+			}
+			storage = &RedisStreamPageStorage{
+				Address: redisURI,
+				Stream:  stream,
+				MaxLen:  1000000,
+			}
+		default:
+			log.Fatalf("Unknown page storage %s", name)
+		}
+		pageStorage.Backends = append(pageStorage.Backends, &PageBackend{Name: name, Storage: storage})
 	}
 
 	// Mongo for jobs storage
@@ -219,34 +364,33 @@ func main() {
 	}
 	pp.Println("jobsStorage", jobsStorage)
 
+	// Comma separated list of proxies, like
+	// socks5://tor1:9050?control=tor1:9051&password=secret,socks5://tor2:9050
 	proxyURI, ok := os.LookupEnv("PROXY_URI")
 	if !ok {
 		logger.Error("You must set PROXY_URI env variable")
 	}
-
-	// instanciate gowap dictionary
-	wapp, err := gowap.Init("./shared/dataset/wappalyzer/apps.json", proxyURI, false)
-	if err != nil {
-		log.Fatal(err)
+	proxyStrategy := proxypool.RoundRobin
+	if *pinProxies {
+		proxyStrategy = proxypool.PinHost
 	}
-
-	bitcoinPatternRegexp, err := regexp.Compile(`[13][a-km-zA-HJ-NP-Z0-9]{26,33}$`)
+	proxies, err := proxypool.New(proxyStrategy, strings.Split(proxyURI, ",")...)
 	if err != nil {
 		log.Fatal(err)
 	}
+	proxies.Isolate = *isolateProxies
 
-	emailPatternRegexp, err := regexp.Compile(`([a-zA-Z0-9_\-\.]+)@([a-zA-Z0-9_\-\.]+)\.([a-zA-Z]{2,5})$`)
+	// instanciate gowap dictionary
+	wapp, err := gowap.Init("./shared/dataset/wappalyzer/apps.json", proxies.Endpoints()[0].URL.String(), false)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// (?:https?://)?(?:www)?(\S*?\.onion)\b
-	onionPatternRegexp, err := regexp.Compile(`(?:https?\:\/\/)?[\w\-\.]+\.onion`)
-	if err != nil {
-		log.Fatal(err)
+	var extractorNames []string
+	if *extractors != "" {
+		extractorNames = strings.Split(*extractors, ",")
 	}
-
-	twitterPatternRegexp, err := regexp.Compile(`(https?\:)?(//)(www[\.])?(twitter.com/)([a-zA-Z0-9_]{1,15})[\/]?`)
+	pipeline, err := extract.New(extractorNames...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -264,25 +408,57 @@ func main() {
 	*/
 
 	spider := &Spider{
-		rdbms:        db,
-		manticore:    cl,
-		storage:      visitedStorage,
-		jobsStorage:  jobsStorage,
-		pageStorage:  pageStorage,
-		proxyURI:     proxyURI,
-		numWorkers:   *numWorkers,
-		parallelism:  *parallelism,
-		depth:        *depth,
-		wapp:         wapp,
-		regexTwitter: twitterPatternRegexp,
-		regexBitcoin: bitcoinPatternRegexp,
-		regexEmail:   emailPatternRegexp,
-		regexOnion:   onionPatternRegexp,
-		Logger:       logger,
+		rdbms:       db,
+		manticore:   cl,
+		storage:     visitedStorage,
+		jobsStorage: jobsStorage,
+		pageStorage: pageStorage,
+		seen:        seenFilter,
+		proxyURI:    proxyURI,
+		proxies:     proxies,
+		numWorkers:  *numWorkers,
+		parallelism: *parallelism,
+		depth:       *depth,
+		wapp:        wapp,
+		extractors:  pipeline,
+		Logger:      logger,
+
+		shutdownTimeout: *shutdownTimeout,
+		minRevisit:      *minRevisit,
+		maxRevisit:      *maxRevisit,
+		maxRetries:      *maxRetries,
+		retryDelay:      *retryDelay,
+		canonicalizer:   canonicalizer,
+		obeyRobots:      *obeyRobots,
+		sitemaps:        *sitemaps,
+		hashImages:      *hashImages,
+		simhashes:       simhash.NewIndex(*nearDistance),
+		mirrorInterval:  *mirrorInterval,
 	}
 
 	pp.Println(spider)
 
+	if *rankPriority {
+		spider.hostRanks, err = spider.loadHostRanks()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var hostRules []hostlimit.Rule
+	if *hostRulesFile != "" {
+		f, err := os.Open(*hostRulesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hostRules, err = hostlimit.ParseRules(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	spider.hosts = hostlimit.New(hostlimit.Rule{Concurrency: *hostConcurrency, Delay: *hostDelay}, hostRules...)
+
 	if *blacklistFile != "" {
 		blacklist, err := readLines(*blacklistFile)
 		if err != nil {
@@ -291,16 +467,58 @@ func main() {
 		spider.blacklist = blacklist
 	}
 
+	if *replayWARC != "" {
+		paths, err := filepath.Glob(*replayWARC)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := spider.replay(paths); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *warcDir != "" {
+		spider.archive, err = warc.NewWriter(*warcDir, "tor-spider", *warcSize<<20)
+		if err != nil {
+			log.Fatal(err)
+		}
+		spider.archive.Info = map[string]string{
+			"software": "tor-spider",
+			"format":   "WARC File Format 1.1",
+		}
+	}
+
 	if *oniontree {
 		spider.importOnionTree("./shared/dataset/oniontree/tagged")
 	}
 
+	// Save all the pending data on SIGINT/SIGTERM
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Infof("Got %v, shutting down", sig)
+		cancel()
+	}()
+
+	if *monitor {
+		spider.startWebAdmin()
+		if err := spider.Monitor(ctx, *monitorInterval); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	err = spider.Init()
 	if err != nil {
 		log.Fatalf("Spider ended with %v", err)
 	}
 
-	spider.Start()
+	if err := spider.Start(ctx); err != nil {
+		logger.Errorf("Spider stopped with %v", err)
+	}
 }
 
 func checkErr(err error) {
@@ -333,44 +551,3 @@ func readLines(path string) ([]string, error) {
 	}
 	return lines, scanner.Err()
 }
-
-func escape(sql string) string {
-	dest := make([]byte, 0, 2*len(sql))
-	var escape byte
-	for i := 0; i < len(sql); i++ {
-		c := sql[i]
-
-		escape = 0
-
-		switch c {
-		case 0: /* Must be escaped for 'mysql' */
-			escape = '0'
-			break
-		case '\n': /* Must be escaped for logs */
-			escape = 'n'
-			break
-		case '\r':
-			escape = 'r'
-			break
-		case '\\':
-			escape = '\\'
-			break
-		case '\'':
-			escape = '\''
-			break
-		case '"': /* Better safe than sorry */
-			escape = '"'
-			break
-		case '\032': /* This gives problems on Win32 */
-			escape = 'Z'
-		}
-
-		if escape != 0 {
-			dest = append(dest, '\\', escape)
-		} else {
-			dest = append(dest, c)
-		}
-	}
-
-	return string(dest)
-}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/samirettali/tor-spider/pkg/manticore"
+)
+
+// manticoreSchema creates the real-time index of the pages. The scores are
+// updated by pushScores.
+const manticoreSchema = `CREATE TABLE IF NOT EXISTS %s (
+	url string,
+	domain string,
+	title text,
+	summary text,
+	keywords text,
+	category string,
+	language string,
+	is_home_page bool,
+	status integer,
+	cluster_id bigint,
+	near_duplicate string,
+	wapp json,
+	page_properties json,
+	created_at timestamp,
+	updated_at timestamp,
+	deleted_at timestamp,
+	pagerank float,
+	hub float,
+	authority float,
+	domain_rank float
+)`
+
+// manticoreScoreColumns are the columns of the index updated by pushScores
+var manticoreScoreColumns = []string{"pagerank", "hub", "authority", "domain_rank"}
+
+// ManticorePageStorage is an implementation of the PageStorage interface
+// writing the pages to a manticore real-time index. Pages are replaced by
+// their id in the database, in bulk requests sent when BufferSize pages are
+// buffered or every FlushInterval.
+type ManticorePageStorage struct {
+	Host          string
+	Port          uint16
+	Index         string
+	BufferSize    int
+	FlushInterval time.Duration
+	Logger        *log.Logger
+
+	pages    chan PageInfo
+	client   manticore.Client
+	done     chan struct{}
+	flushNow chan struct{}
+	closed   bool
+	failed   int
+	onFlush  func(error)
+	mu       sync.RWMutex
+}
+
+// manticoreDocument is the document of a page in the index
+type manticoreDocument struct {
+	URL            string          `json:"url"`
+	Domain         string          `json:"domain"`
+	Title          string          `json:"title"`
+	Summary        string          `json:"summary"`
+	Keywords       string          `json:"keywords"`
+	Category       string          `json:"category"`
+	Language       string          `json:"language"`
+	IsHomePage     bool            `json:"is_home_page"`
+	Status         int             `json:"status"`
+	ClusterID      uint            `json:"cluster_id"`
+	NearDuplicate  string          `json:"near_duplicate"`
+	Wapp           json.RawMessage `json:"wapp,omitempty"`
+	PageProperties PageProperties  `json:"page_properties"`
+	CreatedAt      int64           `json:"created_at"`
+	UpdatedAt      int64           `json:"updated_at"`
+	DeletedAt      int64           `json:"deleted_at"`
+}
+
+func newManticoreDocument(page PageInfo) manticoreDocument {
+	doc := manticoreDocument{
+		URL:            page.URL,
+		Domain:         page.Domain,
+		Title:          page.Title,
+		Summary:        page.Summary,
+		Keywords:       page.Keywords,
+		Category:       page.Category,
+		Language:       page.Language,
+		IsHomePage:     page.IsHomePage,
+		Status:         page.Status,
+		ClusterID:      page.ClusterID,
+		NearDuplicate:  page.NearDuplicate,
+		PageProperties: page.PageProperties,
+		CreatedAt:      page.CreatedAt.Unix(),
+		UpdatedAt:      page.UpdatedAt.Unix(),
+	}
+	if doc.PageProperties == nil {
+		doc.PageProperties = PageProperties{}
+	}
+	if page.Wapp != "" && json.Valid([]byte(page.Wapp)) {
+		doc.Wapp = json.RawMessage(page.Wapp)
+	}
+	if page.DeletedAt != nil {
+		doc.DeletedAt = page.DeletedAt.Unix()
+	}
+	return doc
+}
+
+// Init creates the index if it does not exist and starts the indexer
+func (m *ManticorePageStorage) Init() error {
+	if m.Index == "" {
+		m.Index = "rt_tor_spider"
+	}
+	if m.BufferSize <= 0 {
+		m.BufferSize = 100
+	}
+	if m.FlushInterval <= 0 {
+		m.FlushInterval = 30 * time.Second
+	}
+
+	m.client = manticore.NewClient()
+	m.client.SetServer(m.Host, m.Port)
+	results, err := m.client.Sphinxql(fmt.Sprintf(manticoreSchema, m.Index))
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if result.ErrorCode != 0 {
+			return fmt.Errorf("could not create the index %s: %v", m.Index, result.Msg)
+		}
+	}
+	if err := m.checkSchema(); err != nil {
+		return err
+	}
+
+	m.pages = make(chan PageInfo, m.BufferSize)
+	m.done = make(chan struct{})
+	m.flushNow = make(chan struct{}, 1)
+	go m.batch()
+	return nil
+}
+
+// checkSchema fails when the index lacks some columns of the documents, as
+// when it was created by an older configuration, since manticore would reject
+// every document
+func (m *ManticorePageStorage) checkSchema() error {
+	results, err := m.client.Sphinxql("DESCRIBE " + m.Index)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 || results[0].ErrorCode != 0 {
+		return fmt.Errorf("could not describe the index %s: %v", m.Index, results)
+	}
+	columns := make(map[string]bool)
+	for _, row := range results[0].Rows {
+		if len(row) > 0 {
+			columns[fmt.Sprint(row[0])] = true
+		}
+	}
+
+	required := append([]string{}, manticoreScoreColumns...)
+	doc := reflect.TypeOf(manticoreDocument{})
+	for i := 0; i < doc.NumField(); i++ {
+		required = append(required, strings.Split(doc.Field(i).Tag.Get("json"), ",")[0])
+	}
+	var missing []string
+	for _, column := range required {
+		if !columns[column] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the index %s has no %s columns: drop it to let the spider create it, or add them", m.Index, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// SetFlushHandler sets a function called with the result of every bulk
+// request, an error when manticore is failing. It must be called before Init.
+func (m *ManticorePageStorage) SetFlushHandler(handler func(error)) {
+	m.onFlush = handler
+}
+
+// Flush indexes the queued pages without waiting for the flush interval
+func (m *ManticorePageStorage) Flush() {
+	select {
+	case m.flushNow <- struct{}{}:
+	default:
+	}
+}
+
+// SavePage queues a page for indexing. Pages which could not be saved to the
+// database have no id and are skipped. It is safe for concurrent use.
+func (m *ManticorePageStorage) SavePage(page PageInfo) error {
+	if page.ID == 0 {
+		m.Logger.Warnf("Skipping %s which has not been saved to the database", page.URL)
+		return nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return &SavePageError{Err: "page storage is closed"}
+	}
+	m.pages <- page
+	return nil
+}
+
+// Close indexes all the buffered pages and stops the indexer
+func (m *ManticorePageStorage) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	close(m.pages)
+	m.mu.Unlock()
+
+	<-m.done
+	if m.failed > 0 {
+		return &SavePageError{Err: fmt.Sprintf("Failed to index %d documents", m.failed)}
+	}
+	return nil
+}
+
+// batch groups the queued pages and indexes them
+func (m *ManticorePageStorage) batch() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []PageInfo
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		failures := m.flush(batch)
+		if len(failures) > 0 {
+			m.failed += len(failures)
+			m.Logger.Error(&SavePageError{
+				Err:      fmt.Sprintf("Failed to index %d of %d documents", len(failures), len(batch)),
+				Failures: failures,
+			})
+		} else {
+			m.Logger.Infof("Indexed %d pages", len(batch))
+		}
+		if m.onFlush != nil {
+			var err error
+			// a status of 0 means the request itself failed
+			for _, failure := range failures {
+				if failure.Status == 0 || failure.Status >= 500 {
+					err = fmt.Errorf("could not index %s: %s", failure.ID, failure.Reason)
+					break
+				}
+			}
+			m.onFlush(err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case page, ok := <-m.pages:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, page)
+			if len(batch) >= m.BufferSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-m.flushNow:
+			// take the pages queued before the request
+			for len(m.pages) > 0 {
+				batch = append(batch, <-m.pages)
+			}
+			flush()
+		}
+	}
+}
+
+// manticoreBulkResponse is the answer of the json/bulk endpoint
+type manticoreBulkResponse struct {
+	Items []map[string]struct {
+		ID     uint64          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+	Error json.RawMessage `json:"error"`
+}
+
+// flush replaces the documents of the pages and returns the failures. A
+// status of 0 means the request itself failed.
+func (m *ManticorePageStorage) flush(pages []PageInfo) []DocumentError {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	var ids []string
+	var failures []DocumentError
+	for _, page := range pages {
+		id := strconv.FormatUint(uint64(page.ID), 10)
+		err := enc.Encode(map[string]interface{}{
+			"replace": map[string]interface{}{
+				"index": m.Index,
+				"id":    page.ID,
+				"doc":   newManticoreDocument(page),
+			},
+		})
+		if err != nil {
+			failures = append(failures, DocumentError{ID: id, Reason: err.Error()})
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return failures
+	}
+
+	failAll := func(reason string) []DocumentError {
+		for _, id := range ids {
+			failures = append(failures, DocumentError{ID: id, Reason: reason})
+		}
+		return failures
+	}
+
+	answer, err := m.client.Json("json/bulk", body.String())
+	if err != nil {
+		return failAll(err.Error())
+	}
+	var res manticoreBulkResponse
+	if err := json.Unmarshal([]byte(answer.Answer), &res); err != nil {
+		return failAll(fmt.Sprintf("could not parse the bulk response: %v", err))
+	}
+	if len(res.Error) > 0 && string(res.Error) != "null" {
+		return failAll(string(res.Error))
+	}
+	for _, item := range res.Items {
+		for _, info := range item {
+			if len(info.Error) == 0 || string(info.Error) == "null" {
+				continue
+			}
+			failures = append(failures, DocumentError{
+				ID:     strconv.FormatUint(info.ID, 10),
+				Status: info.Status,
+				Reason: string(info.Error),
+			})
+		}
+	}
+	return failures
+}
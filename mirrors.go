@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"github.com/samirettali/tor-spider/pkg/extract"
+	"github.com/samirettali/tor-spider/pkg/mirrors"
+)
+
+// mirrorIdentifiers are the identifiers which must be shared by the mirrors
+// of a site, the clones publishing other ones being likely phishing sites
+var mirrorIdentifiers = []string{
+	extract.Bitcoin,
+	extract.Monero,
+	extract.Ethereum,
+	extract.Litecoin,
+	extract.Zcash,
+	extract.PGP,
+}
+
+// MirrorCluster is a group of domains serving the same site
+type MirrorCluster struct {
+	gorm.Model
+	Title   string `gorm:"type:text; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"`
+	Size    int
+	Clones  int
+	Domains []MirrorDomain
+}
+
+// MirrorDomain is a domain of a mirror cluster. Clones are the domains whose
+// crypto addresses or PGP keys differ from the rest of the cluster.
+type MirrorDomain struct {
+	gorm.Model
+	MirrorClusterID uint   `gorm:"index:mirror_cluster_id"`
+	Domain          string `gorm:"unique_index"`
+	Clone           bool   `gorm:"index:clone"`
+	Reason          string
+}
+
+// wappTechnologies returns the names of the applications of a wappalyzer
+// analysis
+func wappTechnologies(wapp string) []string {
+	var apps []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(wapp), &apps); err != nil {
+		// the analysis may have been encoded as a JSON string
+		var encoded string
+		if json.Unmarshal([]byte(wapp), &encoded) != nil || json.Unmarshal([]byte(encoded), &apps) != nil {
+			return nil
+		}
+	}
+	var names []string
+	for _, app := range apps {
+		names = append(names, app.Name)
+	}
+	return names
+}
+
+// homePathsBatch is the number of home pages whose links are loaded by a query
+const homePathsBatch = 1000
+
+// homePaths returns the paths of the internal links of the home pages, by
+// domain
+func (spider *Spider) homePaths(homes map[string]PageInfo) (map[string][]string, error) {
+	domains := make(map[string]string, len(homes))
+	hashes := make([]string, 0, len(homes))
+	for domain, home := range homes {
+		hash := strToMD5(home.URL)
+		domains[hash] = domain
+		hashes = append(hashes, hash)
+	}
+
+	paths := make(map[string][]string)
+	for start := 0; start < len(hashes); start += homePathsBatch {
+		end := start + homePathsBatch
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		var links []PageLink
+		err := spider.rdbms.
+			Select("from_hash, to_url").
+			Where("from_hash IN (?) AND to_host = from_host", hashes[start:end]).
+			Find(&links).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range links {
+			if u, err := url.Parse(link.ToURL); err == nil {
+				domain := domains[link.FromHash]
+				paths[domain] = append(paths[domain], u.EscapedPath())
+			}
+		}
+	}
+	return paths, nil
+}
+
+// loadSites returns the latest home page of every crawled domain, with the
+// favicon, the internal links and the identifiers of the domain
+func (spider *Spider) loadSites() ([]mirrors.Site, error) {
+	var pages []PageInfo
+	err := spider.rdbms.
+		Select("id, url, domain, title, sim_hash, wapp").
+		Where("is_home_page = ?", true).
+		Order("id").
+		Find(&pages).Error
+	if err != nil {
+		return nil, err
+	}
+	homes := make(map[string]PageInfo)
+	for _, page := range pages {
+		homes[page.Domain] = page
+	}
+
+	var rows []struct {
+		Domain string
+		Type   string
+		Value  string
+	}
+	err = spider.rdbms.Table("entity_occurrences").
+		Select("DISTINCT entity_occurrences.domain, entities.type, entities.value").
+		Joins("JOIN entities ON entities.id = entity_occurrences.entity_id").
+		Where("entities.type IN (?) AND entity_occurrences.deleted_at IS NULL", mirrorIdentifiers).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	identifiers := make(map[string]map[string][]string)
+	for _, row := range rows {
+		if identifiers[row.Domain] == nil {
+			identifiers[row.Domain] = make(map[string][]string)
+		}
+		identifiers[row.Domain][row.Type] = append(identifiers[row.Domain][row.Type], row.Value)
+	}
+
+	var images []PageImage
+	err = spider.rdbms.Select("domain, md5").Where("kind = ?", ImageFavicon).Order("id").Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	favicons := make(map[string]string)
+	for _, image := range images {
+		favicons[image.Domain] = image.MD5
+	}
+
+	paths, err := spider.homePaths(homes)
+	if err != nil {
+		return nil, err
+	}
+
+	sites := make([]mirrors.Site, 0, len(homes))
+	for domain, home := range homes {
+		sites = append(sites, mirrors.Site{
+			Domain:       domain,
+			Title:        home.Title,
+			SimHash:      home.SimHash,
+			Favicon:      favicons[domain],
+			Technologies: wappTechnologies(home.Wapp),
+			Paths:        paths[domain],
+			Identifiers:  identifiers[domain],
+		})
+	}
+	return sites, nil
+}
+
+// detectMirrors clusters the domains serving the same site and replaces the
+// saved clusters
+func (spider *Spider) detectMirrors() error {
+	sites, err := spider.loadSites()
+	if err != nil {
+		return err
+	}
+	opts := mirrors.DefaultOptions
+	if spider.simhashes != nil {
+		opts.MaxDistance = spider.simhashes.Distance()
+	}
+	clusters := mirrors.Detect(sites, opts)
+
+	tx := spider.rdbms.Begin()
+	if err := tx.Unscoped().Delete(&MirrorDomain{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Unscoped().Delete(&MirrorCluster{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	clones := 0
+	for _, cluster := range clusters {
+		saved := MirrorCluster{
+			Title:  cluster.Title,
+			Size:   len(cluster.Domains),
+			Clones: len(cluster.Clones),
+		}
+		for _, domain := range cluster.Domains {
+			reasons, clone := cluster.Clones[domain]
+			saved.Domains = append(saved.Domains, MirrorDomain{
+				Domain: domain,
+				Clone:  clone,
+				Reason: strings.Join(reasons, ","),
+			})
+		}
+		if err := tx.Create(&saved).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		clones += saved.Clones
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	spider.Logger.Infof("Found %d mirror clusters and %d clones among %d domains", len(clusters), clones, len(sites))
+	return nil
+}
+
+// startMirrorDetection periodically clusters the mirrors
+func (spider *Spider) startMirrorDetection() {
+	if spider.mirrorInterval <= 0 {
+		return
+	}
+	spider.feeders.Add(1)
+	go func() {
+		defer spider.feeders.Done()
+		ticker := time.NewTicker(spider.mirrorInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-spider.quit:
+				return
+			case <-ticker.C:
+				if err := spider.detectMirrors(); err != nil {
+					spider.Logger.Error(err)
+				}
+			}
+		}
+	}()
+}
+
+// mirrorsHandler serves the mirror clusters, largest first. The clones param
+// only keeps the clusters having clones.
+func (spider *Spider) mirrorsHandler(c *gin.Context) {
+	query := spider.rdbms.Preload("Domains").Order("size desc")
+	if c.Query("clones") != "" {
+		query = query.Where("clones > 0")
+	}
+	var clusters []MirrorCluster
+	if err := query.Find(&clusters).Error; err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.JSON(200, clusters)
+}
+
+// mirrorHandler serves the mirror cluster of the domain param
+func (spider *Spider) mirrorHandler(c *gin.Context) {
+	var member MirrorDomain
+	if spider.rdbms.Where("domain = ?", c.Param("domain")).First(&member).RecordNotFound() {
+		c.String(404, "no mirrors found")
+		return
+	}
+	var cluster MirrorCluster
+	if err := spider.rdbms.Preload("Domains").First(&cluster, member.MirrorClusterID).Error; err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.JSON(200, cluster)
+}
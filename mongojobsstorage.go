@@ -3,29 +3,65 @@ package main
 import (
 	"context"
 	"errors"
-	"math/rand"
+	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// MongoJobsStorage is an implementation of the JobsStorage interface
+// MongoJobsStorage is an implementation of the JobsStorage interface.
+// Jobs are leased with an expiry instead of being deleted when they are
+// fetched, so that multiple spiders can share the same collection and a job
+// is never lost if a spider dies while crawling it. The leases of the jobs
+// waiting in the spider queue or being crawled are renewed until they are
+// acknowledged or released.
 type MongoJobsStorage struct {
 	DatabaseName   string
 	CollectionName string
 	Logger         *log.Logger
 	URI            string
+	LeaseDuration  time.Duration
 	jobs           chan Job
 	collection     *mongo.Collection
+	owner          string
+	hosts          []string
+	leased         map[primitive.ObjectID]struct{}
+	quit           chan struct{}
+	renewer        sync.WaitGroup
+	mu             sync.Mutex
+	hostsMu        sync.Mutex
+	leasedMu       sync.Mutex
+}
+
+// mongoJob is the representation of a job inside the collection
+type mongoJob struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	URL         string             `bson:"url"`
+	Host        string             `bson:"host"`
+	Priority    int                `bson:"priority"`
+	Depth       int                `bson:"depth"`
+	From        string             `bson:"from,omitempty"`
+	Attempts    int                `bson:"attempts"`
+	LeasedUntil time.Time          `bson:"leased_until"`
+	LeaseOwner  string             `bson:"lease_owner,omitempty"`
 }
 
 // Init initializes the collection
 func (s *MongoJobsStorage) Init() error {
 	if s.collection == nil {
 		s.jobs = make(chan Job, 100)
+		if s.LeaseDuration == 0 {
+			s.LeaseDuration = 10 * time.Minute
+		}
+		hostname, _ := os.Hostname()
+		s.owner = fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), primitive.NewObjectID().Hex())
+
 		var client *mongo.Client
 		var err error
 		if client, err = mongo.NewClient(options.Client().ApplyURI(s.URI)); err != nil {
@@ -38,51 +74,200 @@ func (s *MongoJobsStorage) Init() error {
 		}
 		db := client.Database(s.DatabaseName)
 		s.collection = db.Collection(s.CollectionName)
+
+		_, err = s.collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+			{Keys: bson.D{{Key: "leased_until", Value: 1}, {Key: "priority", Value: -1}}},
+			{Keys: bson.D{{Key: "host", Value: 1}, {Key: "leased_until", Value: 1}, {Key: "priority", Value: -1}}},
+		})
+		if err != nil {
+			log.Warnln("collection.Indexes", err)
+			return err
+		}
+
+		// jobs saved before leases existed are available right away
+		_, err = s.collection.UpdateMany(
+			context.Background(),
+			bson.M{"leased_until": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"leased_until": time.Time{}}},
+		)
+		if err != nil {
+			log.Warnln("collection.UpdateMany", err)
+			return err
+		}
+
+		s.leased = make(map[primitive.ObjectID]struct{})
+		s.quit = make(chan struct{})
+		s.renewer.Add(1)
+		go s.renewLeases()
 	}
 	return nil
 }
 
-// GetJob returns a job
+// renewLeases extends the leases of the held jobs three times per lease
+// duration
+func (s *MongoJobsStorage) renewLeases() {
+	defer s.renewer.Done()
+	ticker := time.NewTicker(s.LeaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			s.leasedMu.Lock()
+			ids := make([]primitive.ObjectID, 0, len(s.leased))
+			for id := range s.leased {
+				ids = append(ids, id)
+			}
+			s.leasedMu.Unlock()
+			if len(ids) == 0 {
+				continue
+			}
+			_, err := s.collection.UpdateMany(
+				context.Background(),
+				bson.M{"_id": bson.M{"$in": ids}, "lease_owner": s.owner},
+				bson.M{"$set": bson.M{"leased_until": time.Now().Add(s.LeaseDuration)}},
+			)
+			if err != nil {
+				s.Logger.Error(err)
+				continue
+			}
+			s.Logger.Debugf("Renewed the leases of %d jobs", len(ids))
+		}
+	}
+}
+
+// release stops renewing the lease of a job
+func (s *MongoJobsStorage) release(id primitive.ObjectID) {
+	s.leasedMu.Lock()
+	delete(s.leased, id)
+	s.leasedMu.Unlock()
+}
+
+// GetJob leases the job with the highest priority of the next host in the
+// rotation. Jobs whose lease has expired are available again.
 func (s *MongoJobsStorage) GetJob() (Job, error) {
-	var job Job
 	ctx := context.Background()
 
-	// ref
-	// https://github.com/St0iK/go-quote-bot/blob/master/dao/quotes_dao.go
-	rand.Seed(time.Now().UnixNano())
-
-	/*
-		// count documents
-		c, _ := collection.CountDocuments(ctx, bson.D{}, nil)
+	for {
+		host, err := s.nextHost(ctx)
+		if err != nil {
+			return Job{}, err
+		}
+		if host == "" {
+			return s.lease(ctx, bson.M{})
+		}
+		job, err := s.lease(ctx, bson.M{"host": host})
+		if err == nil {
+			return job, nil
+		}
+		if _, ok := err.(*NoJobsError); !ok {
+			return job, err
+		}
+		// the host queue is empty or all its jobs are leased, try the next one
+	}
+}
 
-		// get random doc
-		random := rand.Int63n(c-1) + 1
-		findOptions := options.Find()
-		findOptions.SetLimit(1)
-		findOptions.SetSkip(random)
+// nextHost returns the next host with available jobs, refreshing the list of
+// hosts when every host has had its turn. It returns an empty string when there
+// are no available jobs at all.
+func (s *MongoJobsStorage) nextHost(ctx context.Context) (string, error) {
+	s.hostsMu.Lock()
+	defer s.hostsMu.Unlock()
 
-		cur, err := s.collection.Find(ctx, bson.D{}, findOptions)
+	if len(s.hosts) == 0 {
+		filter := bson.M{"leased_until": bson.M{"$lte": time.Now()}}
+		hosts, err := s.collection.Distinct(ctx, "host", filter)
 		if err != nil {
-			log.Fatal(err)
+			return "", err
+		}
+		for _, host := range hosts {
+			if h, ok := host.(string); ok {
+				s.hosts = append(s.hosts, h)
+			}
+		}
+		if len(s.hosts) == 0 {
+			return "", nil
 		}
-	*/
+	}
+
+	host := s.hosts[0]
+	s.hosts = s.hosts[1:]
+	return host, nil
+}
 
-	err := s.collection.FindOne(ctx, bson.D{}).Decode(&job)
+func (s *MongoJobsStorage) lease(ctx context.Context, filter bson.M) (Job, error) {
+	now := time.Now()
+	filter["leased_until"] = bson.M{"$lte": now}
+	update := bson.M{
+		"$set": bson.M{"leased_until": now.Add(s.LeaseDuration), "lease_owner": s.owner},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var doc mongoJob
+	err := s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&doc)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return job, &NoJobsError{err.Error()}
+			return Job{}, &NoJobsError{err.Error()}
 		}
-		return job, err
+		return Job{}, err
+	}
+	s.leasedMu.Lock()
+	s.leased[doc.ID] = struct{}{}
+	s.leasedMu.Unlock()
+	return Job{
+		ID:       doc.ID.Hex(),
+		URL:      doc.URL,
+		Host:     doc.Host,
+		Priority: doc.Priority,
+		Depth:    doc.Depth,
+		From:     doc.From,
+		Attempts: doc.Attempts,
+	}, nil
+}
+
+// AckJob removes a leased job from the collection once it has been crawled
+func (s *MongoJobsStorage) AckJob(job Job) error {
+	if job.ID == "" {
+		return nil
 	}
-	_, err = s.collection.DeleteOne(ctx, job)
+	id, err := primitive.ObjectIDFromHex(job.ID)
 	if err != nil {
-		return job, err
+		return err
 	}
-	return job, nil
+	s.release(id)
+	_, err = s.collection.DeleteOne(context.Background(), bson.M{"_id": id, "lease_owner": s.owner})
+	return err
+}
+
+// NackJob releases the lease of a job so that it can be fetched again
+func (s *MongoJobsStorage) NackJob(job Job) error {
+	if job.ID == "" {
+		return s.SaveJob(job)
+	}
+	id, err := primitive.ObjectIDFromHex(job.ID)
+	if err != nil {
+		return err
+	}
+	s.release(id)
+	_, err = s.collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": id, "lease_owner": s.owner},
+		bson.M{
+			"$set":   bson.M{"leased_until": time.Time{}, "priority": job.Priority},
+			"$unset": bson.M{"lease_owner": ""},
+		},
+	)
+	return err
 }
 
 // SaveJob adds a job to the jobs channel, upon checking if it's full
 func (s *MongoJobsStorage) SaveJob(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	select {
 	case s.jobs <- job:
 		return nil
@@ -96,12 +281,35 @@ func (s *MongoJobsStorage) SaveJob(job Job) error {
 	}
 }
 
+// Close stops renewing the leases and saves all the buffered jobs to the
+// collection
+func (s *MongoJobsStorage) Close() error {
+	if s.quit != nil {
+		close(s.quit)
+		s.renewer.Wait()
+		s.quit = nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.jobs) == 0 {
+		return nil
+	}
+	return s.flush(len(s.jobs))
+}
+
 func (s *MongoJobsStorage) flush(quantity int) error {
 	ctx := context.Background()
 	jobs := make([]interface{}, 0)
 	for i := 0; i < quantity; i++ {
 		job := <-s.jobs
-		jobs = append(jobs, job)
+		jobs = append(jobs, mongoJob{
+			URL:      job.URL,
+			Host:     job.Host,
+			Priority: job.Priority,
+			Depth:    job.Depth,
+			From:     job.From,
+			Attempts: job.Attempts,
+		})
 	}
 	_, err := s.collection.InsertMany(ctx, jobs)
 	s.Logger.Debugf("Saved %d jobs", quantity)
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// UptimeCheck is a struct used to save the result of a liveness probe of an
+// onion service
+type UptimeCheck struct {
+	gorm.Model
+	Host         string `gorm:"index:host"`
+	Up           bool
+	Status       int
+	ResponseTime time.Duration
+	Error        string    `gorm:"type:text"`
+	Transition   bool      `gorm:"index:transition"`
+	CheckedAt    time.Time `gorm:"index:checked_at"`
+}
+
+// ServiceUptime is the uptime of an onion service over a period of time
+type ServiceUptime struct {
+	Host            string    `json:"host"`
+	Service         string    `json:"service,omitempty"`
+	Checks          int       `json:"checks"`
+	Ups             int       `json:"ups"`
+	Uptime          float64   `json:"uptime"`
+	AvgResponseTime float64   `json:"avg_response_time_ms"`
+	LastChecked     time.Time `json:"last_checked"`
+}
+
+// Monitor probes every known onion service each interval until ctx is
+// cancelled
+func (spider *Spider) Monitor(ctx context.Context, interval time.Duration) error {
+	client := &http.Client{
+		Timeout: 60 * time.Second,
+		Transport: &http.Transport{
+			Proxy: spider.proxies.ProxyFunc(),
+			DialContext: (&net.Dialer{
+				Timeout: 60 * time.Second,
+			}).DialContext,
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		spider.probeHosts(ctx, client)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// knownHosts returns the onion hosts from the oniontree urls and the crawled
+// pages
+func (spider *Spider) knownHosts() ([]string, error) {
+	hosts := make(map[string]bool)
+
+	var names []string
+	if err := spider.rdbms.Model(&URL{}).Pluck("name", &names).Error; err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if u, err := url.Parse(name); err == nil && strings.HasSuffix(u.Hostname(), ".onion") {
+			hosts[strings.ToLower(u.Hostname())] = true
+		}
+	}
+
+	var domains []string
+	if err := spider.rdbms.Model(&PageInfo{}).Where("domain <> ''").Pluck("DISTINCT domain", &domains).Error; err != nil {
+		return nil, err
+	}
+	for _, domain := range domains {
+		hosts[strings.ToLower(domain)+".onion"] = true
+	}
+
+	result := make([]string, 0, len(hosts))
+	for host := range hosts {
+		result = append(result, host)
+	}
+	return result, nil
+}
+
+func (spider *Spider) probeHosts(ctx context.Context, client *http.Client) {
+	hosts, err := spider.knownHosts()
+	if err != nil {
+		spider.Logger.Error(err)
+		return
+	}
+	spider.Logger.Infof("Probing %d onion services", len(hosts))
+
+	sem := make(chan int, spider.numWorkers)
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- 1:
+		}
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			spider.saveUptimeCheck(spider.probe(ctx, client, host))
+			<-sem
+		}(host)
+	}
+	wg.Wait()
+}
+
+// probe checks a host with a HEAD request, falling back to GET for servers
+// which do not support it
+func (spider *Spider) probe(ctx context.Context, client *http.Client, host string) UptimeCheck {
+	check := UptimeCheck{Host: host, CheckedAt: time.Now()}
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, "http://"+host+"/", nil)
+		if err != nil {
+			check.Error = err.Error()
+			return check
+		}
+		start := time.Now()
+		resp, err := client.Do(req)
+		check.ResponseTime = time.Since(start)
+		if err != nil {
+			check.Error = err.Error()
+			check.Status = 0
+			continue
+		}
+		resp.Body.Close()
+		check.Status = resp.StatusCode
+		check.Error = ""
+		if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+	check.Up = check.Error == "" && check.Status < 500
+	return check
+}
+
+func (spider *Spider) saveUptimeCheck(check UptimeCheck) {
+	var last UptimeCheck
+	if spider.rdbms.Where("host = ?", check.Host).Order("checked_at desc").First(&last).RecordNotFound() {
+		check.Transition = true
+	} else {
+		check.Transition = last.Up != check.Up
+	}
+	if check.Transition {
+		spider.Logger.Infof("%s is now up=%t", check.Host, check.Up)
+	}
+
+	if err := spider.rdbms.Create(&check).Error; err != nil {
+		spider.Logger.Error(err)
+		return
+	}
+	err := spider.rdbms.Model(&URL{}).
+		Where("name LIKE ?", "%"+check.Host+"%").
+		Update("healthy", check.Up).Error
+	if err != nil {
+		spider.Logger.Error(err)
+	}
+}
+
+// uptimes returns the uptime of the services checked since the given time,
+// optionally filtered by host
+func (spider *Spider) uptimes(since time.Time, host string) ([]ServiceUptime, error) {
+	type row struct {
+		Host            string
+		Checks          int
+		Ups             int
+		AvgResponseTime float64
+		LastChecked     time.Time
+	}
+	query := spider.rdbms.Model(&UptimeCheck{}).
+		Select("host, COUNT(*) AS checks, SUM(up) AS ups, AVG(response_time) AS avg_response_time, MAX(checked_at) AS last_checked").
+		Where("checked_at >= ?", since).
+		Group("host")
+	if host != "" {
+		query = query.Where("host = ?", host)
+	}
+	var rows []row
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]ServiceUptime, 0, len(rows))
+	for _, r := range rows {
+		uptime := ServiceUptime{
+			Host:            r.Host,
+			Checks:          r.Checks,
+			Ups:             r.Ups,
+			AvgResponseTime: r.AvgResponseTime / float64(time.Millisecond),
+			LastChecked:     r.LastChecked,
+		}
+		if r.Checks > 0 {
+			uptime.Uptime = 100 * float64(r.Ups) / float64(r.Checks)
+		}
+		var u URL
+		if !spider.rdbms.Where("name LIKE ?", "%"+r.Host+"%").First(&u).RecordNotFound() && u.ServiceID != 0 {
+			var svc Service
+			if !spider.rdbms.First(&svc, u.ServiceID).RecordNotFound() {
+				uptime.Service = svc.Name
+			}
+		}
+		result = append(result, uptime)
+	}
+	return result, nil
+}
+
+// uptimeHandler serves the uptime of the services, over the period given by
+// the `since` duration parameter (24h by default)
+func (spider *Spider) uptimeHandler(c *gin.Context) {
+	period := 24 * time.Hour
+	if s, ok := c.GetQuery("since"); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			c.String(400, err.Error())
+			return
+		}
+		period = d
+	}
+	uptimes, err := spider.uptimes(time.Now().Add(-period), c.Param("host"))
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.JSON(200, uptimes)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/samirettali/tor-spider/pkg/breaker"
+)
+
+// PageBackend is a storage of a MultiPageStorage. Its pages are queued and
+// saved by its own worker, retried up to MaxRetries times, and dropped while
+// its circuit breaker is open, after FailureThreshold consecutive failures and
+// until Cooldown expires.
+type PageBackend struct {
+	Name             string
+	Storage          PageStorage
+	QueueSize        int
+	MaxRetries       int
+	RetryDelay       time.Duration
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	queue   chan PageInfo
+	breaker *breaker.Breaker
+	async   bool
+	dropped uint64
+	failed  uint64
+}
+
+// asyncPageStorage is a PageStorage whose SavePage only queues the pages. The
+// results of its flushes are reported to the handler it is given, so that its
+// breaker opens when the backend is failing.
+type asyncPageStorage interface {
+	PageStorage
+	SetFlushHandler(handler func(error))
+	// Flush saves the queued pages without waiting for the next flush
+	Flush()
+}
+
+// MultiPageStorage is an implementation of the PageStorage interface which
+// saves the pages to several backends, so that a slow or failing backend does
+// not block the others
+type MultiPageStorage struct {
+	Backends []*PageBackend
+	Logger   *log.Logger
+
+	workers sync.WaitGroup
+	closed  bool
+	mu      sync.RWMutex
+}
+
+// Init initializes the backends and starts their workers
+func (m *MultiPageStorage) Init() error {
+	for _, b := range m.Backends {
+		if b.QueueSize <= 0 {
+			b.QueueSize = 1000
+		}
+		if b.MaxRetries <= 0 {
+			b.MaxRetries = 3
+		}
+		if b.RetryDelay <= 0 {
+			b.RetryDelay = time.Second
+		}
+		if b.FailureThreshold <= 0 {
+			b.FailureThreshold = 5
+		}
+		if b.Cooldown <= 0 {
+			b.Cooldown = time.Minute
+		}
+		b.queue = make(chan PageInfo, b.QueueSize)
+		b.breaker = breaker.New(b.FailureThreshold, b.Cooldown)
+		if storage, ok := b.Storage.(asyncPageStorage); ok {
+			b.async = true
+			backend := b
+			storage.SetFlushHandler(func(err error) {
+				m.flushed(backend, err)
+			})
+		}
+		if err := b.Storage.Init(); err != nil {
+			return fmt.Errorf("%s page storage: %v", b.Name, err)
+		}
+	}
+	m.workers.Add(len(m.Backends))
+	for _, b := range m.Backends {
+		go m.worker(b)
+	}
+	return nil
+}
+
+// SavePage queues a page for every backend. A page is dropped for the
+// backends whose queue is full. It is safe for concurrent use.
+func (m *MultiPageStorage) SavePage(page PageInfo) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return &SavePageError{Err: "page storage is closed"}
+	}
+	for _, b := range m.Backends {
+		select {
+		case b.queue <- page:
+		default:
+			atomic.AddUint64(&b.dropped, 1)
+			m.Logger.Warnf("The queue of the %s page storage is full, dropping %s", b.Name, page.URL)
+		}
+	}
+	return nil
+}
+
+// Close saves the queued pages and closes the backends
+func (m *MultiPageStorage) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	for _, b := range m.Backends {
+		close(b.queue)
+	}
+	m.mu.Unlock()
+
+	m.workers.Wait()
+	var errs []string
+	for _, b := range m.Backends {
+		if err := b.Storage.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", b.Name, err))
+		}
+		failed, dropped := atomic.LoadUint64(&b.failed), atomic.LoadUint64(&b.dropped)
+		if failed > 0 || dropped > 0 {
+			errs = append(errs, fmt.Sprintf("%s: %d pages failed and %d were dropped", b.Name, failed, dropped))
+		}
+	}
+	if len(errs) > 0 {
+		return &SavePageError{Err: fmt.Sprintf("Failed to save pages to some storages: %v", errs)}
+	}
+	return nil
+}
+
+// worker saves the queued pages of a backend
+func (m *MultiPageStorage) worker(b *PageBackend) {
+	defer m.workers.Done()
+	for page := range b.queue {
+		if !b.breaker.Allow() {
+			atomic.AddUint64(&b.dropped, 1)
+			m.Logger.Warnf("The %s page storage is unavailable, dropping %s", b.Name, page.URL)
+			continue
+		}
+		if err := m.save(b, page); err != nil {
+			atomic.AddUint64(&b.failed, 1)
+			m.Logger.Errorf("Could not save %s to the %s page storage: %v", page.URL, b.Name, err)
+			if b.breaker.Failure() {
+				m.Logger.Warnf("The %s page storage is failing, dropping its pages for %v", b.Name, b.Cooldown)
+			}
+			continue
+		}
+		// the pages queued by an async storage succeed once flushed. The trial
+		// page of a half-open breaker is flushed right away, since the pages
+		// are dropped until its result is known.
+		if !b.async {
+			b.breaker.Success()
+		} else if b.breaker.State() == breaker.HalfOpen {
+			b.Storage.(asyncPageStorage).Flush()
+		}
+	}
+}
+
+// flushed reports the result of a flush of an async backend to its breaker
+func (m *MultiPageStorage) flushed(b *PageBackend, err error) {
+	if err == nil {
+		b.breaker.Success()
+		return
+	}
+	if b.breaker.Failure() {
+		m.Logger.Warnf("The %s page storage is failing (%v), dropping its pages for %v", b.Name, err, b.Cooldown)
+	}
+}
+
+// save saves a page to a backend, retrying with an exponential backoff
+func (m *MultiPageStorage) save(b *PageBackend, page PageInfo) error {
+	retryBackoff := backoff.NewExponentialBackOff()
+	retryBackoff.InitialInterval = b.RetryDelay
+	retryBackoff.MaxElapsedTime = 0
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = b.Storage.SavePage(page); err == nil || attempt >= b.MaxRetries {
+			return err
+		}
+		m.Logger.Debugf("Retrying to save %s to the %s page storage: %v", page.URL, b.Name, err)
+		time.Sleep(retryBackoff.NextBackOff())
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/samirettali/tor-spider/pkg/breaker"
+)
+
+// fakePageStorage saves the pages in memory. Its next failures calls fail, or
+// all of them when failures is negative, and every call waits for block when
+// it is not nil.
+type fakePageStorage struct {
+	failures int
+	block    chan struct{}
+	calls    int
+	saved    []string
+	mu       sync.Mutex
+}
+
+func (f *fakePageStorage) Init() error  { return nil }
+func (f *fakePageStorage) Close() error { return nil }
+
+func (f *fakePageStorage) SavePage(page PageInfo) error {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.failures != 0 {
+		if f.failures > 0 {
+			f.failures--
+		}
+		return errors.New("backend is down")
+	}
+	f.saved = append(f.saved, page.URL)
+	return nil
+}
+
+func (f *fakePageStorage) setFailures(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = n
+}
+
+func (f *fakePageStorage) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// fakeAsyncPageStorage only queues the pages, its flushes are reported by the
+// tests through onFlush
+type fakeAsyncPageStorage struct {
+	fakePageStorage
+	onFlush func(error)
+	flushes int32
+}
+
+func (f *fakeAsyncPageStorage) SetFlushHandler(handler func(error)) {
+	f.onFlush = handler
+}
+
+func (f *fakeAsyncPageStorage) Flush() {
+	atomic.AddInt32(&f.flushes, 1)
+}
+
+func newTestMultiPageStorage(t *testing.T, backends ...*PageBackend) *MultiPageStorage {
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	m := &MultiPageStorage{Backends: backends, Logger: logger}
+	if err := m.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// waitFor waits until cond is true
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMultiPageStorageQueueFull(t *testing.T) {
+	slow := &fakePageStorage{block: make(chan struct{})}
+	fast := &fakePageStorage{}
+	m := newTestMultiPageStorage(t,
+		&PageBackend{Name: "slow", Storage: slow, QueueSize: 1},
+		&PageBackend{Name: "fast", Storage: fast, QueueSize: 10},
+	)
+	// the worker of the slow backend holds the first page, the queue the
+	// second one
+	m.SavePage(PageInfo{URL: "http://a.onion/"})
+	waitFor(t, "the slow worker", func() bool { return len(m.Backends[0].queue) == 0 })
+	for _, u := range []string{"http://b.onion/", "http://c.onion/", "http://d.onion/"} {
+		m.SavePage(PageInfo{URL: u})
+	}
+	close(slow.block)
+
+	err := m.Close()
+	if err == nil {
+		t.Error("Close should report the dropped pages")
+	}
+	if dropped := m.Backends[0].dropped; dropped != 2 {
+		t.Errorf("2 pages should be dropped, got %d", dropped)
+	}
+	if len(slow.saved) != 2 || len(fast.saved) != 4 {
+		t.Errorf("a full queue should not block the other backends, got %v and %v", slow.saved, fast.saved)
+	}
+}
+
+func TestMultiPageStorageRetries(t *testing.T) {
+	storage := &fakePageStorage{failures: 2}
+	m := newTestMultiPageStorage(t, &PageBackend{Name: "flaky", Storage: storage, MaxRetries: 3, RetryDelay: time.Millisecond})
+	m.SavePage(PageInfo{URL: "http://a.onion/"})
+	if err := m.Close(); err != nil {
+		t.Error(err)
+	}
+	if storage.calls != 3 || len(storage.saved) != 1 {
+		t.Errorf("the page should be saved at the third attempt, got %d calls", storage.calls)
+	}
+}
+
+func TestMultiPageStorageBreaker(t *testing.T) {
+	storage := &fakePageStorage{failures: -1}
+	backend := &PageBackend{
+		Name:             "down",
+		Storage:          storage,
+		MaxRetries:       1,
+		RetryDelay:       time.Millisecond,
+		FailureThreshold: 2,
+		Cooldown:         50 * time.Millisecond,
+	}
+	m := newTestMultiPageStorage(t, backend)
+	defer m.Close()
+
+	// two failed pages open the breaker, and the next pages are dropped
+	for _, u := range []string{"http://a.onion/", "http://b.onion/", "http://c.onion/"} {
+		m.SavePage(PageInfo{URL: u})
+	}
+	waitFor(t, "the dropped page", func() bool { return atomic.LoadUint64(&backend.dropped) == 1 })
+	if calls := storage.Calls(); calls != 4 {
+		t.Errorf("the open breaker should not call the backend, got %d calls", calls)
+	}
+	if state := backend.breaker.State(); state != breaker.Open {
+		t.Errorf("breaker should be open, got %v", state)
+	}
+
+	// after the cooldown a trial page closes the breaker
+	storage.setFailures(0)
+	time.Sleep(backend.Cooldown)
+	m.SavePage(PageInfo{URL: "http://d.onion/"})
+	m.SavePage(PageInfo{URL: "http://e.onion/"})
+	waitFor(t, "the recovery", func() bool { return storage.Calls() == 6 })
+	if state := backend.breaker.State(); state != breaker.Closed {
+		t.Errorf("breaker should be closed, got %v", state)
+	}
+}
+
+func TestMultiPageStorageAsyncBreaker(t *testing.T) {
+	storage := &fakeAsyncPageStorage{}
+	backend := &PageBackend{
+		Name:             "async",
+		Storage:          storage,
+		FailureThreshold: 2,
+		Cooldown:         50 * time.Millisecond,
+	}
+	m := newTestMultiPageStorage(t, backend)
+	defer m.Close()
+	if storage.onFlush == nil {
+		t.Fatal("the flush handler should be set")
+	}
+
+	// queueing a page is not a success
+	storage.onFlush(errors.New("bulk request failed"))
+	m.SavePage(PageInfo{URL: "http://a.onion/"})
+	waitFor(t, "the queued page", func() bool { return storage.Calls() == 1 })
+	storage.onFlush(errors.New("bulk request failed"))
+	if state := backend.breaker.State(); state != breaker.Open {
+		t.Fatalf("failed flushes should open the breaker, got %v", state)
+	}
+	m.SavePage(PageInfo{URL: "http://b.onion/"})
+	waitFor(t, "the dropped page", func() bool { return atomic.LoadUint64(&backend.dropped) == 1 })
+
+	// the trial page is flushed right away, and its flush closes the breaker
+	time.Sleep(backend.Cooldown)
+	m.SavePage(PageInfo{URL: "http://c.onion/"})
+	waitFor(t, "the trial flush", func() bool { return atomic.LoadInt32(&storage.flushes) == 1 })
+	storage.onFlush(nil)
+	if state := backend.breaker.State(); state != breaker.Closed {
+		t.Errorf("a successful flush should close the breaker, got %v", state)
+	}
+	m.SavePage(PageInfo{URL: "http://d.onion/"})
+	waitFor(t, "the last page", func() bool { return storage.Calls() == 3 })
+}
@@ -0,0 +1,93 @@
+package main
+
+// Kinds of near duplicate pages
+const (
+	// NearDuplicatePage is a near duplicate of a page of the same domain, like
+	// a captcha or a login page with a random token
+	NearDuplicatePage = "duplicate"
+	// NearDuplicateMirror is a near duplicate of a page of another domain, like
+	// a mirror or a clone of a service
+	NearDuplicateMirror = "mirror"
+)
+
+// loadSimHashes indexes the SimHash of the saved pages
+func (spider *Spider) loadSimHashes() error {
+	rows, err := spider.rdbms.Model(&PageInfo{}).Select("id, sim_hash").Where("sim_hash <> 0").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uint
+		var hash uint64
+		if err := rows.Scan(&id, &hash); err != nil {
+			return err
+		}
+		spider.simhashes.Add(id, hash)
+	}
+	spider.Logger.Infof("Indexed %d page hashes", spider.simhashes.Len())
+	return rows.Err()
+}
+
+// nearDuplicates returns the ids of the saved pages close to page, closest
+// first. Pages without text are compared by their exact fingerprint.
+func (spider *Spider) nearDuplicates(page *PageInfo) ([]uint, error) {
+	var ids []uint
+	if page.SimHash == 0 {
+		err := spider.rdbms.Model(&PageInfo{}).
+			Where("fingerprint = ? AND id <> ?", page.Fingerprint, page.ID).
+			Order("id").Limit(1).
+			Pluck("id", &ids).Error
+		return ids, err
+	}
+	for _, match := range spider.simhashes.Search(page.SimHash) {
+		if match.ID != page.ID {
+			ids = append(ids, match.ID)
+		}
+	}
+	return ids, nil
+}
+
+// clusterPage looks for a near duplicate of page among the saved pages. When
+// one is found, page joins its cluster and is marked as a duplicate or a
+// mirror depending on its domain.
+func (spider *Spider) clusterPage(page *PageInfo) error {
+	page.ClusterID = 0
+	page.NearDuplicate = ""
+	ids, err := spider.nearDuplicates(page)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		var original PageInfo
+		if spider.rdbms.Select("id, domain, cluster_id").First(&original, id).RecordNotFound() {
+			spider.simhashes.Remove(id)
+			continue
+		}
+		if original.ClusterID == 0 {
+			// the first page of a cluster gives its id to the cluster
+			original.ClusterID = original.ID
+			err := spider.rdbms.Model(&original).UpdateColumn("cluster_id", original.ClusterID).Error
+			if err != nil {
+				return err
+			}
+		}
+		page.ClusterID = original.ClusterID
+		page.NearDuplicate = NearDuplicatePage
+		if original.Domain != page.Domain {
+			page.NearDuplicate = NearDuplicateMirror
+		}
+		spider.Logger.Debugf("link=%s is a %s of page %d", page.URL, page.NearDuplicate, id)
+		return nil
+	}
+	return nil
+}
+
+// indexSimHash adds a saved page to the near duplicates index
+func (spider *Spider) indexSimHash(page *PageInfo) {
+	if page.SimHash != 0 {
+		spider.simhashes.Add(page.ID, page.SimHash)
+	} else {
+		spider.simhashes.Remove(page.ID)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	stdioutil "io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/goccy/go-yaml"
 	"github.com/gosimple/slug"
@@ -55,9 +56,17 @@ type PublicKey struct {
 	UID         string `gorm:"primary_key" json:"id,omitempty" yaml:"id,omitempty"`
 	UserID      string `json:"user_id,omitempty" yaml:"user_id,omitempty"`
 	Fingerprint string `json:"fingerprint,omitempty" yaml:"fingerprint,omitempty"`
-	Description string `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext" json:"description,omitempty" yaml:"description,omitempty"`
-	Value       string `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext" json:"value" yaml:"value"`
-	ServiceID   uint   `json:"-" yaml:"-"`
+	// FingerprintKey is the upper-case fingerprint without spaces, set on save
+	FingerprintKey string `gorm:"size:40;index:fingerprint_key" json:"-" yaml:"-"`
+	Description    string `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext" json:"description,omitempty" yaml:"description,omitempty"`
+	Value          string `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext" json:"value" yaml:"value"`
+	ServiceID      uint   `json:"-" yaml:"-"`
+	// filled when the key is found in a crawled page
+	KeyID        string          `gorm:"index:key_id" json:"key_id,omitempty" yaml:"-"`
+	UserIDs      string          `gorm:"type:text" json:"user_ids,omitempty" yaml:"-"`
+	Emails       string          `gorm:"type:text" json:"emails,omitempty" yaml:"-"`
+	KeyCreatedAt *time.Time      `json:"key_created_at,omitempty" yaml:"-"`
+	Pages        []PagePublicKey `json:"-" yaml:"-"`
 }
 
 func (spider *Spider) importOnionTree(dirname string) {
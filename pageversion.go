@@ -0,0 +1,110 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// PageVersion is a struct used to keep the history of the contents of a page
+// across revisits
+type PageVersion struct {
+	gorm.Model
+	PageInfoID  uint   `gorm:"index:page_info_id"`
+	Fingerprint string `gorm:"index:fingerprint"`
+	Status      int
+	Title       string `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext"`
+	Summary     string `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext"`
+	CrawledAt   time.Time
+}
+
+// savePageInfo stores a crawled page. A revisited page is updated in place and
+// a new version is added to its history when its content changed. Near
+// duplicates of saved pages are stored in their cluster. It returns false when
+// there is nothing new to index. Replayed pages only replace the stored ones
+// archived before them.
+func (spider *Spider) savePageInfo(page *PageInfo) (bool, error) {
+	crawledAt := page.UpdatedAt
+	var existing PageInfo
+	if spider.rdbms.Where("url = ?", page.URL).Order("id desc").First(&existing).RecordNotFound() {
+		if err := spider.clusterPage(page); err != nil {
+			return false, err
+		}
+		spider.Logger.Debug("Insert into db...")
+		page.Versions = []PageVersion{newPageVersion(page)}
+		if err := spider.rdbms.Create(page).Error; err != nil {
+			return false, err
+		}
+		spider.indexSimHash(page)
+		if page.NearDuplicate == NearDuplicatePage {
+			// captchas and login pages of a domain are only indexed once
+			spider.Logger.Debugf("skipping link=%s as similar content already exists\n", page.URL)
+			return false, nil
+		}
+		return true, nil
+	}
+
+	if spider.offline && !crawledAt.After(existing.UpdatedAt) {
+		spider.Logger.Debugf("link=%s archived at %v is older than the stored page", page.URL, crawledAt)
+		return false, nil
+	}
+
+	if existing.Fingerprint == page.Fingerprint {
+		spider.Logger.Debugf("link=%s did not change since %v", page.URL, existing.UpdatedAt)
+		// Updates would date the page now rather than when it was crawled
+		err := spider.rdbms.Model(&existing).UpdateColumns(map[string]interface{}{
+			"status":     page.Status,
+			"updated_at": crawledAt,
+		}).Error
+		return false, err
+	}
+
+	spider.Logger.Debugf("link=%s changed since %v", page.URL, existing.UpdatedAt)
+	tx := spider.rdbms.Begin()
+	if err := tx.Where("page_info_id = ?", existing.ID).Delete(&PageAttribute{}).Error; err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	page.ID = existing.ID
+	page.CreatedAt = existing.CreatedAt
+	if err := spider.clusterPage(page); err != nil {
+		return false, err
+	}
+	page.Versions = []PageVersion{newPageVersion(page)}
+	if err := tx.Save(page).Error; err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	// Save dates the update now rather than when the page was crawled
+	err := tx.Model(&PageInfo{}).Where("id = ?", page.ID).UpdateColumn("updated_at", crawledAt).Error
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return false, err
+	}
+	page.UpdatedAt = crawledAt
+	spider.indexSimHash(page)
+	return page.NearDuplicate != NearDuplicatePage, nil
+}
+
+// storedAfter returns true when the last stored crawl of a page is not older
+// than at, a replayed response of the page is then outdated
+func (spider *Spider) storedAfter(pageURL string, at time.Time) bool {
+	var existing PageInfo
+	if spider.rdbms.Select("updated_at").Where("url = ?", pageURL).Order("id desc").First(&existing).RecordNotFound() {
+		return false
+	}
+	return !at.After(existing.UpdatedAt)
+}
+
+func newPageVersion(page *PageInfo) PageVersion {
+	return PageVersion{
+		Fingerprint: page.Fingerprint,
+		Status:      page.Status,
+		Title:       page.Title,
+		Summary:     page.Summary,
+		CrawledAt:   page.UpdatedAt,
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/samirettali/tor-spider/pkg/extract"
+)
+
+// PagePublicKey links a PGP public key to a page publishing it, in order to
+// pivot across the services sharing a key
+type PagePublicKey struct {
+	gorm.Model
+	PublicKeyID uint   `gorm:"unique_index:page_public_key"`
+	URLHash     string `gorm:"size:32;unique_index:page_public_key"`
+	URL         string `gorm:"type:text"`
+	Domain      string `gorm:"index:domain"`
+}
+
+// normalizeFingerprint returns the upper-case fingerprint without spaces, as
+// found by the PGP extractor
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.Replace(fingerprint, " ", "", -1))
+}
+
+// BeforeSave sets the normalized fingerprint of the key
+func (k *PublicKey) BeforeSave() error {
+	k.FingerprintKey = normalizeFingerprint(k.Fingerprint)
+	return nil
+}
+
+// migratePublicKeys sets the normalized fingerprint of the keys saved before
+// it existed
+func migratePublicKeys(db *gorm.DB) error {
+	return db.Exec("UPDATE public_keys SET fingerprint_key = UPPER(REPLACE(fingerprint, ' ', '')) WHERE fingerprint_key IS NULL OR fingerprint_key = ''").Error
+}
+
+// serviceID returns the id of the oniontree service hosting host, or 0
+func (spider *Spider) serviceID(host string) uint {
+	var u URL
+	if spider.rdbms.Where("name LIKE ?", "%"+host+"%").First(&u).RecordNotFound() {
+		return 0
+	}
+	return u.ServiceID
+}
+
+// savePublicKeys upserts the PGP public keys found in a page and links them to
+// the page and to the service hosting it
+func (spider *Spider) savePublicKeys(pageURL, domain string, keys []extract.PublicKey) {
+	if len(keys) == 0 {
+		return
+	}
+	var serviceID uint
+	if u, err := url.Parse(pageURL); err == nil {
+		serviceID = spider.serviceID(u.Hostname())
+	}
+
+	for _, key := range keys {
+		var publicKey PublicKey
+		notFound := spider.rdbms.
+			Where("fingerprint_key = ?", normalizeFingerprint(key.Fingerprint)).
+			First(&publicKey).RecordNotFound()
+		if notFound {
+			publicKey = PublicKey{
+				UID:         key.KeyID,
+				Fingerprint: key.Fingerprint,
+				Value:       key.Armored,
+			}
+		}
+		if len(key.UserIDs) > 0 && publicKey.UserID == "" {
+			publicKey.UserID = key.UserIDs[0]
+		}
+		if publicKey.ServiceID == 0 {
+			publicKey.ServiceID = serviceID
+		}
+		publicKey.KeyID = key.KeyID
+		publicKey.UserIDs = strings.Join(key.UserIDs, "|")
+		publicKey.Emails = strings.Join(key.Emails, ",")
+		createdAt := key.CreatedAt
+		publicKey.KeyCreatedAt = &createdAt
+		if err := spider.rdbms.Save(&publicKey).Error; err != nil {
+			spider.Logger.Error(err)
+			continue
+		}
+
+		link := PagePublicKey{
+			PublicKeyID: publicKey.ID,
+			URLHash:     strToMD5(pageURL),
+			URL:         pageURL,
+			Domain:      domain,
+		}
+		err := spider.rdbms.
+			Where(PagePublicKey{PublicKeyID: link.PublicKeyID, URLHash: link.URLHash}).
+			Assign(PagePublicKey{Domain: domain}).
+			FirstOrCreate(&link).Error
+		if err != nil {
+			spider.Logger.Error(err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Package breaker implements a circuit breaker, which stops calling a failing
+// backend for a while instead of piling up requests behind it.
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a breaker
+type State int
+
+const (
+	// Closed lets all the calls through
+	Closed State = iota
+	// Open rejects the calls until the cooldown expires
+	Open
+	// HalfOpen lets a single trial call through after the cooldown
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Breaker opens after Threshold consecutive failures and lets a trial call
+// through every Cooldown, closing again when the trial succeeds
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	state    State
+	failures int
+	openedAt time.Time
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+// New returns a closed breaker
+func New(threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	return &Breaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Allow tells whether a call may be made. Once the cooldown of an open
+// breaker has expired, only the first caller is allowed to make a trial call.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.Cooldown {
+			return false
+		}
+		b.state = HalfOpen
+		return true
+	case HalfOpen:
+		return false
+	}
+	return true
+}
+
+// Success reports a successful call, closing the breaker
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = Closed
+	b.failures = 0
+}
+
+// Failure reports a failed call. It returns true when the breaker opens.
+func (b *Breaker) Failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == HalfOpen || (b.state == Closed && b.failures >= b.Threshold) {
+		b.state = Open
+		b.openedAt = b.now()
+		return true
+	}
+	return false
+}
+
+// State returns the state of the breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.Cooldown {
+		return HalfOpen
+	}
+	return b.state
+}
@@ -0,0 +1,69 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOpensAfterThreshold(t *testing.T) {
+	b := New(3, time.Minute)
+	for i := 0; i < 2; i++ {
+		if b.Failure() {
+			t.Fatalf("should not open after %d failures", i+1)
+		}
+	}
+	if !b.Allow() {
+		t.Error("should allow calls below the threshold")
+	}
+	if !b.Failure() {
+		t.Error("should open after 3 failures")
+	}
+	if b.Allow() {
+		t.Error("should reject calls while open")
+	}
+	if b.State() != Open {
+		t.Errorf("state should be open, got %s", b.State())
+	}
+}
+
+func TestSuccessResetsFailures(t *testing.T) {
+	b := New(2, time.Minute)
+	b.Failure()
+	b.Success()
+	if b.Failure() {
+		t.Error("failures should be reset by a success")
+	}
+}
+
+func TestHalfOpen(t *testing.T) {
+	now := time.Now()
+	b := New(1, time.Minute)
+	b.now = func() time.Time { return now }
+	b.Failure()
+
+	now = now.Add(time.Minute)
+	if b.State() != HalfOpen {
+		t.Errorf("state should be half-open after the cooldown, got %s", b.State())
+	}
+	if !b.Allow() {
+		t.Fatal("should allow a trial call after the cooldown")
+	}
+	if b.Allow() {
+		t.Error("should allow a single trial call")
+	}
+	if !b.Failure() {
+		t.Error("a failed trial should open the breaker again")
+	}
+	if b.Allow() {
+		t.Error("should reject calls after a failed trial")
+	}
+
+	now = now.Add(time.Minute)
+	if !b.Allow() {
+		t.Fatal("should allow a trial call after the second cooldown")
+	}
+	b.Success()
+	if b.State() != Closed || !b.Allow() {
+		t.Error("a successful trial should close the breaker")
+	}
+}
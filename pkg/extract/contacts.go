@@ -0,0 +1,136 @@
+package extract
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// Names of the contact attributes
+const (
+	Telegram = "telegram"
+	XMPP     = "xmpp"
+	Session  = "session"
+	Wickr    = "wickr"
+	Tox      = "tox"
+	IRC      = "irc"
+	Matrix   = "matrix"
+)
+
+// contactExtractor finds the identifiers matching the first group of a
+// pattern, keeping the valid ones in their normalized form
+type contactExtractor struct {
+	name      string
+	pattern   *regexp.Regexp
+	normalize func(match []string) string
+	valid     func(id string) bool
+}
+
+func (e *contactExtractor) Name() string {
+	return e.name
+}
+
+func (e *contactExtractor) Extract(page *Page) []Attribute {
+	var result []Attribute
+	for _, match := range e.pattern.FindAllStringSubmatch(string(page.Body), -1) {
+		id := e.normalize(match)
+		if id == "" || (e.valid != nil && !e.valid(id)) {
+			continue
+		}
+		result = append(result, Attribute{Name: e.name, Value: id})
+	}
+	return result
+}
+
+// telegramReserved are t.me paths which are not accounts
+var telegramReserved = map[string]bool{
+	"share": true, "addstickers": true, "addtheme": true, "setlanguage": true,
+	"proxy": true, "socks": true, "iv": true, "login": true,
+}
+
+// normalizeTelegram returns t.me/<username> for accounts and channels, and
+// keeps the case of the private invite links
+func normalizeTelegram(match []string) string {
+	if match[1] != "" {
+		return "t.me/joinchat/" + match[3]
+	}
+	if match[2] != "" {
+		return "t.me/+" + match[3]
+	}
+	username := strings.ToLower(match[3])
+	if telegramReserved[username] || strings.Contains(username, "-") || username[0] < 'a' || username[0] > 'z' {
+		return ""
+	}
+	return "t.me/" + username
+}
+
+func lowerGroup(match []string) string {
+	return strings.ToLower(match[1])
+}
+
+// validTox checks the checksum of a Tox ID: the xor of the 2 bytes chunks of
+// the public key and nospam
+func validTox(id string) bool {
+	data, err := hex.DecodeString(id)
+	if err != nil || len(data) != 38 {
+		return false
+	}
+	var checksum [2]byte
+	for i := 0; i < 36; i++ {
+		checksum[i%2] ^= data[i]
+	}
+	return checksum[0] == data[36] && checksum[1] == data[37]
+}
+
+// normalizeIRC returns irc://host/#channel
+func normalizeIRC(match []string) string {
+	host := strings.ToLower(match[1])
+	channel := strings.TrimPrefix(match[2], "#")
+	if channel == "" {
+		return "irc://" + host
+	}
+	return "irc://" + host + "/#" + strings.ToLower(channel)
+}
+
+func init() {
+	Register(&contactExtractor{
+		name:      Telegram,
+		pattern:   regexp.MustCompile(`(?i)\b(?:https?://)?(?:t|telegram)\.(?:me|dog)/(?:(joinchat/)|(\+))?([A-Za-z0-9_\-]{5,32})\b`),
+		normalize: normalizeTelegram,
+	})
+	Register(&contactExtractor{
+		name:      XMPP,
+		pattern:   regexp.MustCompile(`(?i)(?:\bxmpp:|\b(?:jabber|xmpp|jid)\s*(?:id)?\s*[:=\-]\s*)([a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,})`),
+		normalize: lowerGroup,
+	})
+	Register(&contactExtractor{
+		name:      Session,
+		pattern:   regexp.MustCompile(`\b(05[0-9a-fA-F]{64})\b`),
+		normalize: lowerGroup,
+	})
+	Register(&contactExtractor{
+		name:      Wickr,
+		pattern:   regexp.MustCompile(`(?i)\bwickr(?:\s*me)?(?:\s*id)?\s*[:=\-]\s*@?([a-z0-9_.\-]{5,32})\b`),
+		normalize: lowerGroup,
+	})
+	Register(&contactExtractor{
+		name:    Tox,
+		pattern: regexp.MustCompile(`\b([0-9a-fA-F]{76})\b`),
+		normalize: func(match []string) string {
+			return strings.ToUpper(match[1])
+		},
+		valid: validTox,
+	})
+	Register(&contactExtractor{
+		name:      IRC,
+		pattern:   regexp.MustCompile(`(?i)\bircs?://([a-z0-9.\-]+\.[a-z0-9]{2,})(?::\d+)?/?(#?[^\s/"'<>,]*)`),
+		normalize: normalizeIRC,
+	})
+	Register(&contactExtractor{
+		name:    Matrix,
+		pattern: regexp.MustCompile(`(?i)(?:matrix\.to/#/([@#!][a-z0-9._=\-/]+:[a-z0-9.\-]+\.[a-z]{2,})|(?:^|[\s>"'(])(@[a-z0-9._=\-/]+:[a-z0-9.\-]+\.[a-z]{2,}))`),
+		normalize: func(match []string) string {
+			return strings.ToLower(match[1] + match[2])
+		},
+	})
+}
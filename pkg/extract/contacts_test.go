@@ -0,0 +1,32 @@
+package extract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContacts(t *testing.T) {
+	pipeline, err := New(Telegram, XMPP, Session, Wickr, Tox, IRC, Matrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := pipeline.Extract(loadPage(t, "contacts.html"))
+
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{Telegram, []string{"t.me/example_vendor", "t.me/examplenews", "t.me/joinchat/AAAAAEkK2Wx-Ab_12cd", "t.me/+Xy12_abCDef"}},
+		{XMPP, []string{"vendor@jabber.example.org", "support@xmpp.example.net"}},
+		{Session, []string{"05073c71a6db10457aafe4194e83b8ed22578cc1f62b6095caff34699ed3083d72"}},
+		{Wickr, []string{"example_vendor1"}},
+		{Tox, []string{"0B30557A9FC4E90E33587DA2C7EC11365B80A5CAEF14395E83A8CDF2173C6186ABD0F51AFE8A"}},
+		{IRC, []string{"irc://irc.example.net/#market"}},
+		{Matrix, []string{"@vendor:matrix.example.org", "#market:matrix.example.org"}},
+	}
+	for _, test := range tests {
+		if got := values(attrs, test.name); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package extract
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Names of the cryptocurrency attributes
+const (
+	Bitcoin  = "bitcoin"
+	Monero   = "monero"
+	Ethereum = "ethereum"
+	Litecoin = "litecoin"
+	Zcash    = "zcash"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var (
+	base58Pattern = regexp.MustCompile(`\b[1-9A-HJ-NP-Za-km-z]{26,35}\b`)
+	moneroPattern = regexp.MustCompile(`\b[48][1-9A-HJ-NP-Za-km-z]{94}(?:[1-9A-HJ-NP-Za-km-z]{11})?\b`)
+	bech32Pattern = regexp.MustCompile(`(?i)\b(?:bc|ltc|zs)1[02-9ac-hj-np-z]{6,87}\b`)
+	etherPattern  = regexp.MustCompile(`\b0x[0-9a-fA-F]{40}\b`)
+)
+
+// base58check versions of the transparent addresses
+var base58Versions = map[string]string{
+	"00":   Bitcoin,  // P2PKH
+	"05":   Bitcoin,  // P2SH
+	"30":   Litecoin, // P2PKH
+	"32":   Litecoin, // P2SH
+	"1cb8": Zcash,    // t1 P2PKH
+	"1cbd": Zcash,    // t3 P2SH
+}
+
+// bech32 human readable parts
+var bech32Prefixes = map[string]string{
+	"bc":  Bitcoin,
+	"ltc": Litecoin,
+	"zs":  Zcash,
+}
+
+// monero network bytes of the standard, integrated and subaddresses
+var moneroPrefixes = map[byte]bool{18: true, 19: true, 42: true}
+
+type cryptoExtractor struct{}
+
+func (cryptoExtractor) Name() string {
+	return "crypto"
+}
+
+// Extract finds the cryptocurrency addresses of the page, keeping only the
+// ones with a valid checksum
+func (cryptoExtractor) Extract(page *Page) []Attribute {
+	var result []Attribute
+	for _, match := range base58Pattern.FindAll(page.Body, -1) {
+		if name := checkBase58Address(string(match)); name != "" {
+			result = append(result, Attribute{Name: name, Value: string(match)})
+		}
+	}
+	for _, match := range moneroPattern.FindAll(page.Body, -1) {
+		if checkMoneroAddress(string(match)) {
+			result = append(result, Attribute{Name: Monero, Value: string(match)})
+		}
+	}
+	for _, match := range bech32Pattern.FindAll(page.Body, -1) {
+		if name := checkBech32Address(string(match)); name != "" {
+			result = append(result, Attribute{Name: name, Value: strings.ToLower(string(match))})
+		}
+	}
+	for _, match := range etherPattern.FindAll(page.Body, -1) {
+		if checkEthereumAddress(string(match)) {
+			result = append(result, Attribute{Name: Ethereum, Value: string(match)})
+		}
+	}
+	return result
+}
+
+func init() {
+	Register(cryptoExtractor{})
+}
+
+// decodeBase58 decodes s keeping its leading zeros
+func decodeBase58(s string) []byte {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		digit := strings.IndexRune(base58Alphabet, c)
+		if digit < 0 {
+			return nil
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...)
+}
+
+// checkBase58Address returns the currency of a base58check address, or an
+// empty string if it is not valid
+func checkBase58Address(address string) string {
+	data := decodeBase58(address)
+	if len(data) < 25 {
+		return ""
+	}
+	payload, checksum := data[:len(data)-4], data[len(data)-4:]
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	if !bytes.Equal(second[:4], checksum) {
+		return ""
+	}
+	// the version is followed by a 20 bytes hash
+	version := hex.EncodeToString(payload[:len(payload)-20])
+	return base58Versions[version]
+}
+
+// moneroBlockSizes maps the size of an encoded block to its decoded size
+var moneroBlockSizes = map[int]int{2: 1, 3: 2, 5: 3, 6: 4, 7: 5, 9: 6, 10: 7, 11: 8}
+
+// decodeMoneroBase58 decodes the monero variant of base58, which encodes
+// blocks of 8 bytes in 11 characters
+func decodeMoneroBase58(s string) []byte {
+	var result []byte
+	for len(s) > 0 {
+		size := 11
+		if len(s) < size {
+			size = len(s)
+		}
+		decodedSize, ok := moneroBlockSizes[size]
+		if !ok {
+			return nil
+		}
+		block := decodeBase58(strings.TrimLeft(s[:size], "1"))
+		if block == nil || len(block) > decodedSize {
+			return nil
+		}
+		result = append(result, make([]byte, decodedSize-len(block))...)
+		result = append(result, block...)
+		s = s[size:]
+	}
+	return result
+}
+
+func keccak256(data []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// checkMoneroAddress tells whether address is a valid monero standard,
+// integrated or subaddress
+func checkMoneroAddress(address string) bool {
+	data := decodeMoneroBase58(address)
+	if len(data) != 69 && len(data) != 77 {
+		return false
+	}
+	if !moneroPrefixes[data[0]] {
+		return false
+	}
+	payload, checksum := data[:len(data)-4], data[len(data)-4:]
+	return bytes.Equal(keccak256(payload)[:4], checksum)
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32 and bech32m checksum constants
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// convertBits regroups 5 bits words into bytes, without padding
+func convertBits(data []byte) ([]byte, bool) {
+	var result []byte
+	acc, bits := 0, 0
+	for _, v := range data {
+		acc = acc<<5 | int(v)
+		bits += 5
+		for bits >= 8 {
+			bits -= 8
+			result = append(result, byte(acc>>uint(bits)))
+		}
+		acc &= (1 << uint(bits)) - 1
+	}
+	return result, bits < 5 && acc == 0
+}
+
+// checkBech32Address returns the currency of a bech32 or bech32m address, or
+// an empty string if it is not valid
+func checkBech32Address(address string) string {
+	if address != strings.ToLower(address) && address != strings.ToUpper(address) {
+		return ""
+	}
+	address = strings.ToLower(address)
+	sep := strings.LastIndexByte(address, '1')
+	hrp, encoded := address[:sep], address[sep+1:]
+	name, ok := bech32Prefixes[hrp]
+	if !ok || len(encoded) < 6 {
+		return ""
+	}
+
+	values := make([]byte, 0, len(hrp)*2+1+len(encoded))
+	for _, c := range hrp {
+		values = append(values, byte(c>>5))
+	}
+	values = append(values, 0)
+	for _, c := range hrp {
+		values = append(values, byte(c&31))
+	}
+	data := make([]byte, len(encoded))
+	for i, c := range encoded {
+		data[i] = byte(strings.IndexRune(bech32Charset, c))
+	}
+	values = append(values, data...)
+	checksum := bech32Polymod(values)
+	data = data[:len(data)-6]
+
+	if name == Zcash {
+		// sapling addresses are bech32 encoded 43 bytes
+		program, ok := convertBits(data)
+		if checksum != bech32Const || !ok || len(program) != 43 {
+			return ""
+		}
+		return name
+	}
+
+	// segwit addresses start with the witness version, 0 for bech32 and 1 to
+	// 16 for bech32m
+	if len(data) == 0 || data[0] > 16 {
+		return ""
+	}
+	version := data[0]
+	if (version == 0 && checksum != bech32Const) || (version > 0 && checksum != bech32mConst) {
+		return ""
+	}
+	program, ok := convertBits(data[1:])
+	if !ok || len(program) < 2 || len(program) > 40 {
+		return ""
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return ""
+	}
+	return name
+}
+
+// checkEthereumAddress tells whether address is a valid ethereum address.
+// Mixed case addresses must match their EIP-55 checksum.
+func checkEthereumAddress(address string) bool {
+	digits := address[2:]
+	lower := strings.ToLower(digits)
+	if digits == lower || digits == strings.ToUpper(digits) {
+		return true
+	}
+	hash := hex.EncodeToString(keccak256([]byte(lower)))
+	for i, c := range digits {
+		if c >= '0' && c <= '9' {
+			continue
+		}
+		upper := c >= 'A' && c <= 'F'
+		if upper != (hash[i] >= '8') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+package extract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCrypto(t *testing.T) {
+	attrs := cryptoExtractor{}.Extract(loadPage(t, "crypto.html"))
+
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{Bitcoin, []string{
+			"1BoatSLRHtKNngkdXEeobR76b53LETtpyT",
+			"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy",
+			"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+			"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0",
+		}},
+		{Monero, []string{"44AFFq5kSiGBoZ4NMDwYtN18obc8AemS33DBLWs3H7otXft3XjrpDtQGv7SqSsaBYBb98uNbr2VBBEt7f2wfn3RVGQBEP3A"}},
+		{Ethereum, []string{"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"}},
+		{Litecoin, []string{"LKW2MEtSaS8nhzywDkMeV45Wt5FjFizyaC", "ltc1qq5gpkf3383r4yhtgwdlgn9yl426upj7k57edzy"}},
+		{Zcash, []string{"t1J9g6MzkU6gL3qLfz3BULr7fvX5XrmygtW", "zs1qy8pk2p4gf84c6tkswgfm24hcngaa6lcq5fp7tpegefkqmt6s722rt4mer279mlupytzxcc4e5w"}},
+	}
+	for _, test := range tests {
+		if got := values(attrs, test.name); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestEthereumChecksum(t *testing.T) {
+	tests := map[string]bool{
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359": true,
+		"0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359": true,
+		"0xFB6916095CA1DF60BB79CE92CE3EA74C37C5D359": true,
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d35A": false,
+		"0xFb6916095ca1df60bB79Ce92cE3Ea74c37c5d359": false,
+	}
+	for address, valid := range tests {
+		if checkEthereumAddress(address) != valid {
+			t.Errorf("%s should be valid=%t", address, valid)
+		}
+	}
+}
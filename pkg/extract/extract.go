@@ -0,0 +1,117 @@
+// Package extract runs a configurable set of extractors over the crawled pages
+// to find typed attributes like email addresses, cryptocurrency addresses or
+// social network accounts.
+package extract
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Page is the input of the extractors
+type Page struct {
+	URL    *url.URL
+	Header http.Header
+	Body   []byte
+	DOM    *goquery.Document
+	// Text is the article text of the page
+	Text string
+
+	// publicKeys caches the keys found by FindPublicKeys
+	publicKeys []PublicKey
+	keysFound  bool
+}
+
+// Attribute is a typed value found in a page
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+// Extractor finds attributes in a page
+type Extractor interface {
+	// Name identifies the extractor in the configuration
+	Name() string
+	Extract(page *Page) []Attribute
+}
+
+var (
+	registry   = make(map[string]Extractor)
+	registryMu sync.RWMutex
+)
+
+// Register makes an extractor available by its name. It panics if an
+// extractor with the same name is already registered.
+func Register(e Extractor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[e.Name()]; ok {
+		panic("extract: Register called twice for extractor " + e.Name())
+	}
+	registry[e.Name()] = e
+}
+
+// Names returns the sorted names of the registered extractors
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Pipeline is a list of extractors run in order
+type Pipeline []Extractor
+
+// New returns a pipeline of the named extractors, or of every registered
+// extractor if no name is given
+func New(names ...string) (Pipeline, error) {
+	if len(names) == 0 {
+		names = Names()
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	pipeline := make(Pipeline, 0, len(names))
+	for _, name := range names {
+		e, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("extract: unknown extractor %q", name)
+		}
+		pipeline = append(pipeline, e)
+	}
+	return pipeline, nil
+}
+
+// Extract runs every extractor over page and returns the attributes found,
+// without duplicates
+func (p Pipeline) Extract(page *Page) []Attribute {
+	var result []Attribute
+	seen := make(map[Attribute]bool)
+	for _, e := range p {
+		for _, attr := range e.Extract(page) {
+			if !seen[attr] {
+				seen[attr] = true
+				result = append(result, attr)
+			}
+		}
+	}
+	return result
+}
+
+// Has tells whether the pipeline runs the named extractor
+func (p Pipeline) Has(name string) bool {
+	for _, e := range p {
+		if e.Name() == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package extract
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// loadPage reads an HTML fixture from testdata
+func loadPage(t *testing.T, name string) *Page {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dom, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://example.onion/" + name)
+	return &Page{URL: u, Body: body, DOM: dom, Text: dom.Find("body").Text()}
+}
+
+// values returns the values of the attributes named name
+func values(attrs []Attribute, name string) []string {
+	var result []string
+	for _, attr := range attrs {
+		if attr.Name == name {
+			result = append(result, attr.Value)
+		}
+	}
+	return result
+}
+
+func TestBuiltins(t *testing.T) {
+	pipeline, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := pipeline.Extract(loadPage(t, "market.html"))
+
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{"email", []string{"admin@example.onion", "support@mail2tor.com"}},
+		{"bitcoin", []string{"1BoatSLRHtKNngkdXEeobR76b53LETtpyT"}},
+		{"twitter", []string{"https://twitter.com/example_market", "https://www.twitter.com/backup_acct/"}},
+	}
+	for _, test := range tests {
+		if got := values(attrs, test.name); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestNoMatches(t *testing.T) {
+	pipeline, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs := pipeline.Extract(loadPage(t, "empty.html")); len(attrs) != 0 {
+		t.Errorf("should find nothing, got %v", attrs)
+	}
+}
+
+func TestNew(t *testing.T) {
+	pipeline, err := New("twitter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pipeline.Has("twitter") || pipeline.Has("email") {
+		t.Errorf("pipeline should only have the twitter extractor")
+	}
+	attrs := pipeline.Extract(loadPage(t, "market.html"))
+	if len(values(attrs, "email")) != 0 || len(values(attrs, "twitter")) != 2 {
+		t.Errorf("only the enabled extractors should run, got %v", attrs)
+	}
+
+	if _, err := New("nope"); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("should fail on unknown extractors, got %v", err)
+	}
+}
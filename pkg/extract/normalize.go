@@ -0,0 +1,34 @@
+package extract
+
+import "strings"
+
+// Normalize returns value in the form the extractor of the attributes named
+// name gives to it, so that an identifier typed by a user can be looked up
+func Normalize(name, value string) string {
+	value = strings.TrimSpace(value)
+	switch name {
+	case Onion:
+		return strings.ToLower(value)
+	case PGP:
+		return strings.ToUpper(strings.Join(strings.Fields(value), ""))
+	case Tox:
+		return strings.ToUpper(value)
+	case XMPP, Session, Wickr, IRC, Matrix:
+		return strings.ToLower(value)
+	case Telegram:
+		// private invite links are case sensitive
+		if strings.HasPrefix(value, "t.me/joinchat/") || strings.HasPrefix(value, "t.me/+") {
+			return value
+		}
+		return strings.ToLower(value)
+	case Bitcoin, Litecoin, Zcash:
+		// bech32 addresses are lowercased, base58 ones are case sensitive
+		lower := strings.ToLower(value)
+		for prefix := range bech32Prefixes {
+			if strings.HasPrefix(lower, prefix+"1") {
+				return lower
+			}
+		}
+	}
+	return value
+}
@@ -0,0 +1,24 @@
+package extract
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name, value, want string
+	}{
+		{Onion, " ExampleExampleEx.ONION ", "exampleexampleex.onion"},
+		{PGP, "be68 474a a3df 0390 d4d0  b577 4772 6aec 2892 cd2e", "BE68474AA3DF0390D4D0B57747726AEC2892CD2E"},
+		{Tox, "56a1adE4b65b86bcd51cc73e2cd8e542ded08df9bcd8caa8e1db4c5e8a6d8a4e91ea25c3a1e2", "56A1ADE4B65B86BCD51CC73E2CD8E542DED08DF9BCD8CAA8E1DB4C5E8A6D8A4E91EA25C3A1E2"},
+		{XMPP, "Vendor@Jabber.org", "vendor@jabber.org"},
+		{Telegram, "t.me/Vendor", "t.me/vendor"},
+		{Telegram, "t.me/joinchat/AbCdEf", "t.me/joinchat/AbCdEf"},
+		{Bitcoin, "BC1QAR0SRRR7XFKVY5L643LYDNW9RE59GTZZWF5MDQ", "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"},
+		{Bitcoin, "1BoatSLRHtKNngkdXEeobR76b53LETtpyT", "1BoatSLRHtKNngkdXEeobR76b53LETtpyT"},
+		{"email", "Vendor@Example.com", "Vendor@Example.com"},
+	}
+	for _, test := range tests {
+		if got := Normalize(test.name, test.value); got != test.want {
+			t.Errorf("Normalize(%q, %q) should be %q, got %q", test.name, test.value, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/base32"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Onion is the name of the onion address attributes
+const Onion = "onion"
+
+var onionPattern = regexp.MustCompile(`(?i)\b(?:[a-z2-7]{56}|[a-z2-7]{16})\.onion\b`)
+
+// ValidOnion tells whether host is a v2 onion address or a v3 one with a valid
+// checksum and version
+func ValidOnion(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, ".onion"))
+	switch len(host) {
+	case 16:
+		_, err := base32.StdEncoding.DecodeString(strings.ToUpper(host))
+		return err == nil
+	case 56:
+		// onion_address = base32(pubkey | checksum | version)
+		data, err := base32.StdEncoding.DecodeString(strings.ToUpper(host))
+		if err != nil || len(data) != 35 {
+			return false
+		}
+		pubkey, checksum, version := data[:32], data[32:34], data[34]
+		if version != 3 {
+			return false
+		}
+		h := sha3.New256()
+		h.Write([]byte(".onion checksum"))
+		h.Write(pubkey)
+		h.Write([]byte{version})
+		return bytes.Equal(h.Sum(nil)[:2], checksum)
+	}
+	return false
+}
+
+// FindOnions returns the valid onion addresses mentioned anywhere in body,
+// lowercased and without duplicates
+func FindOnions(body []byte) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, match := range onionPattern.FindAll(body, -1) {
+		host := strings.ToLower(string(match))
+		if !seen[host] && ValidOnion(host) {
+			seen[host] = true
+			result = append(result, host)
+		}
+	}
+	return result
+}
+
+type onionExtractor struct{}
+
+func (onionExtractor) Name() string {
+	return Onion
+}
+
+func (onionExtractor) Extract(page *Page) []Attribute {
+	var result []Attribute
+	for _, host := range FindOnions(page.Body) {
+		result = append(result, Attribute{Name: Onion, Value: host})
+	}
+	return result
+}
+
+func init() {
+	Register(onionExtractor{})
+}
@@ -0,0 +1,39 @@
+package extract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindOnions(t *testing.T) {
+	page := loadPage(t, "onions.html")
+	expected := []string{
+		"aaaqeayeaudaocajbifqydiob4ibceqtcqkrmfyydenbwha5dyp3kead.onion",
+		"duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion",
+		"mrswmz3infvgw3dnnzxxa4lson2hk5txpb4xu634pv7h7aebqkb5woid.onion",
+		"expyuzz4wqqyqhjn.onion",
+	}
+	if got := FindOnions(page.Body); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if attrs := (onionExtractor{}).Extract(page); len(values(attrs, Onion)) != len(expected) {
+		t.Errorf("should have %d onion attributes, got %v", len(expected), attrs)
+	}
+}
+
+func TestValidOnion(t *testing.T) {
+	tests := map[string]bool{
+		"duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion": true,
+		"duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad":       true,
+		"expyuzz4wqqyqhjn.onion": true,
+		// wrong version byte
+		"aaaqeayeaudaocajbifqydiob4ibceqtcqkrmfyydenbwha5dyp3keac.onion": false,
+		"duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczae.onion": false,
+		"expyuzz4wqqyqhj1.onion": false,
+	}
+	for host, valid := range tests {
+		if ValidOnion(host) != valid {
+			t.Errorf("%s should be valid=%t", host, valid)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package extract
+
+import (
+	"fmt"
+	"html"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/crypto/openpgp"
+)
+
+// PGP is the name of the PGP public key attributes
+const PGP = "pgp"
+
+var (
+	pgpBlockPattern = regexp.MustCompile(`(?s)-----BEGIN PGP PUBLIC KEY BLOCK-----.*?-----END PGP PUBLIC KEY BLOCK-----`)
+	tagPattern      = regexp.MustCompile(`<[^>]*>`)
+)
+
+// PublicKey is a PGP public key found in a page
+type PublicKey struct {
+	Fingerprint string
+	KeyID       string
+	UserIDs     []string
+	Emails      []string
+	CreatedAt   time.Time
+	// Armored is the cleaned up armored key block
+	Armored string
+}
+
+// cleanBlock turns a key block found in the raw HTML into a valid armored
+// block, removing the markup and the indentation of the lines
+func cleanBlock(block string) string {
+	block = tagPattern.ReplaceAllString(block, "\n")
+	block = html.UnescapeString(block)
+	lines := strings.Split(strings.Replace(block, "\r", "", -1), "\n")
+	var cleaned []string
+	inBody := false
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case len(cleaned) == 0, inBody:
+			cleaned = append(cleaned, line)
+		case strings.Contains(line, ": "):
+			// armor headers, like Version or Comment
+			cleaned = append(cleaned, line)
+		default:
+			// the body is separated from the headers by a blank line
+			cleaned = append(cleaned, "", line)
+			inBody = true
+		}
+	}
+	return strings.Join(cleaned, "\n") + "\n"
+}
+
+// ParsePublicKeys parses an armored block, which may contain several keys
+func ParsePublicKeys(armored string) ([]PublicKey, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, err
+	}
+	var keys []PublicKey
+	for _, entity := range entities {
+		key := PublicKey{
+			Fingerprint: fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint),
+			KeyID:       entity.PrimaryKey.KeyIdString(),
+			CreatedAt:   entity.PrimaryKey.CreationTime,
+			Armored:     armored,
+		}
+		for name, identity := range entity.Identities {
+			key.UserIDs = append(key.UserIDs, name)
+			email := identity.UserId.Email
+			if email == "" {
+				if addr, err := mail.ParseAddress(name); err == nil {
+					email = addr.Address
+				}
+			}
+			if email != "" {
+				key.Emails = append(key.Emails, strings.ToLower(email))
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// FindPublicKeys returns the PGP public keys published in the page, either in
+// its markup or in its pre, textarea and code elements. The keys are parsed
+// once per page, so that the PGP extractor and its callers share them.
+func FindPublicKeys(page *Page) []PublicKey {
+	if page.keysFound {
+		return page.publicKeys
+	}
+	page.publicKeys, page.keysFound = findPublicKeys(page), true
+	return page.publicKeys
+}
+
+func findPublicKeys(page *Page) []PublicKey {
+	blocks := pgpBlockPattern.FindAllString(string(page.Body), -1)
+	if page.DOM != nil {
+		page.DOM.Find("pre, textarea, code").Each(func(i int, s *goquery.Selection) {
+			blocks = append(blocks, pgpBlockPattern.FindAllString(s.Text(), -1)...)
+		})
+	}
+
+	var keys []PublicKey
+	seen := make(map[string]bool)
+	for _, block := range blocks {
+		parsed, err := ParsePublicKeys(cleanBlock(block))
+		if err != nil {
+			continue
+		}
+		for _, key := range parsed {
+			if !seen[key.Fingerprint] {
+				seen[key.Fingerprint] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+type pgpExtractor struct{}
+
+func (pgpExtractor) Name() string {
+	return PGP
+}
+
+// Extract returns the fingerprints of the PGP public keys of the page
+func (pgpExtractor) Extract(page *Page) []Attribute {
+	var result []Attribute
+	for _, key := range FindPublicKeys(page) {
+		result = append(result, Attribute{Name: PGP, Value: key.Fingerprint})
+	}
+	return result
+}
+
+func init() {
+	Register(pgpExtractor{})
+}
@@ -0,0 +1,52 @@
+package extract
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindPublicKeys(t *testing.T) {
+	keys := FindPublicKeys(loadPage(t, "pgp.html"))
+	if len(keys) != 1 {
+		t.Fatalf("should find 1 key, got %d", len(keys))
+	}
+	key := keys[0]
+	if key.Fingerprint != "BE68474AA3DF0390D4D0B57747726AEC2892CD2E" {
+		t.Errorf("unexpected fingerprint %s", key.Fingerprint)
+	}
+	if key.KeyID != "47726AEC2892CD2E" {
+		t.Errorf("unexpected key id %s", key.KeyID)
+	}
+	if !reflect.DeepEqual(key.UserIDs, []string{"Example Vendor <vendor@example.onion>"}) {
+		t.Errorf("unexpected user ids %v", key.UserIDs)
+	}
+	if !reflect.DeepEqual(key.Emails, []string{"vendor@example.onion"}) {
+		t.Errorf("unexpected emails %v", key.Emails)
+	}
+	if !key.CreatedAt.Equal(time.Unix(1792259676, 0)) {
+		t.Errorf("unexpected creation date %v", key.CreatedAt)
+	}
+
+	attrs := pgpExtractor{}.Extract(loadPage(t, "pgp.html"))
+	if !reflect.DeepEqual(values(attrs, PGP), []string{key.Fingerprint}) {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+}
+
+func TestCleanBlock(t *testing.T) {
+	block := "-----BEGIN PGP PUBLIC KEY BLOCK-----<br>\n  Version: 1<br>\n  Comment: x<br>\n<br>\n  AAAA<br>\n  =BBBB<br>\n-----END PGP PUBLIC KEY BLOCK-----"
+	expected := "-----BEGIN PGP PUBLIC KEY BLOCK-----\nVersion: 1\nComment: x\n\nAAAA\n=BBBB\n-----END PGP PUBLIC KEY BLOCK-----\n"
+	if cleaned := cleanBlock(block); cleaned != expected {
+		t.Errorf("unexpected block %q", cleaned)
+	}
+}
+
+func TestFindPublicKeysOnce(t *testing.T) {
+	page := loadPage(t, "pgp.html")
+	pgpExtractor{}.Extract(page)
+	page.Body, page.DOM = nil, nil
+	if keys := FindPublicKeys(page); len(keys) != 1 {
+		t.Errorf("keys found by the extractor should be reused, got %d", len(keys))
+	}
+}
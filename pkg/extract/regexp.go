@@ -0,0 +1,30 @@
+package extract
+
+import "regexp"
+
+type regexpExtractor struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// NewRegexp returns an extractor of the matches of re in the raw body
+func NewRegexp(name string, re *regexp.Regexp) Extractor {
+	return &regexpExtractor{name: name, re: re}
+}
+
+func (e *regexpExtractor) Name() string {
+	return e.name
+}
+
+func (e *regexpExtractor) Extract(page *Page) []Attribute {
+	var result []Attribute
+	for _, match := range e.re.FindAll(page.Body, -1) {
+		result = append(result, Attribute{Name: e.name, Value: string(match)})
+	}
+	return result
+}
+
+func init() {
+	Register(NewRegexp("email", regexp.MustCompile(`([a-zA-Z0-9_\-\.]+)@([a-zA-Z0-9_\-\.]+)\.([a-zA-Z]{2,5})\b`)))
+	Register(NewRegexp("twitter", regexp.MustCompile(`(https?\:)?(//)(www[\.])?(twitter.com/)([a-zA-Z0-9_]{1,15})[\/]?`)))
+}
@@ -0,0 +1,192 @@
+// Package graph holds a directed weighted graph and exports it to formats
+// understood by graph analysis tools such as Gephi or networkx.
+package graph
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// Edge is a directed edge between two nodes
+type Edge struct {
+	Source string
+	Target string
+	Weight int
+	Label  string
+}
+
+// Graph is a directed weighted graph
+type Graph struct {
+	nodes map[string]int
+	order []string
+	Edges []Edge
+}
+
+// New returns an empty graph
+func New() *Graph {
+	return &Graph{nodes: make(map[string]int)}
+}
+
+// AddNode adds a node to the graph if it does not exist yet
+func (g *Graph) AddNode(id string) {
+	if _, ok := g.nodes[id]; !ok {
+		g.nodes[id] = len(g.order)
+		g.order = append(g.order, id)
+	}
+}
+
+// AddEdge adds an edge to the graph, adding its nodes if needed
+func (g *Graph) AddEdge(source, target string, weight int, label string) {
+	g.AddNode(source)
+	g.AddNode(target)
+	g.Edges = append(g.Edges, Edge{source, target, weight, label})
+}
+
+// Nodes returns the nodes of the graph in insertion order
+func (g *Graph) Nodes() []string {
+	return g.order
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphmlKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphmlNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphmlData `xml:"data"`
+}
+
+type graphml struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphmlNode `xml:"node"`
+		Edges       []graphmlEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+// WriteGraphML writes the graph in the GraphML format
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := graphml{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphmlKey{
+			{ID: "label", For: "node", AttrName: "label", AttrType: "string"},
+			{ID: "weight", For: "edge", AttrName: "weight", AttrType: "int"},
+			{ID: "anchor", For: "edge", AttrName: "label", AttrType: "string"},
+		},
+	}
+	doc.Graph.ID = "G"
+	doc.Graph.EdgeDefault = "directed"
+	for _, id := range g.order {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphmlNode{
+			ID:   id,
+			Data: []graphmlData{{Key: "label", Value: id}},
+		})
+	}
+	for i, edge := range g.Edges {
+		e := graphmlEdge{
+			ID:     "e" + strconv.Itoa(i),
+			Source: edge.Source,
+			Target: edge.Target,
+			Data:   []graphmlData{{Key: "weight", Value: strconv.Itoa(edge.Weight)}},
+		}
+		if edge.Label != "" {
+			e.Data = append(e.Data, graphmlData{Key: "anchor", Value: edge.Label})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, e)
+	}
+	return writeXML(w, doc)
+}
+
+type gexfNode struct {
+	ID    string `xml:"id,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type gexfEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+	Weight int    `xml:"weight,attr"`
+	Label  string `xml:"label,attr,omitempty"`
+}
+
+type gexf struct {
+	XMLName xml.Name `xml:"gexf"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Graph   struct {
+		DefaultEdgeType string     `xml:"defaultedgetype,attr"`
+		Nodes           []gexfNode `xml:"nodes>node"`
+		Edges           []gexfEdge `xml:"edges>edge"`
+	} `xml:"graph"`
+}
+
+// WriteGEXF writes the graph in the GEXF 1.3 format
+func (g *Graph) WriteGEXF(w io.Writer) error {
+	doc := gexf{XMLNS: "http://gexf.net/1.3", Version: "1.3"}
+	doc.Graph.DefaultEdgeType = "directed"
+	for _, id := range g.order {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{ID: id, Label: id})
+	}
+	for i, edge := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{
+			ID:     strconv.Itoa(i),
+			Source: edge.Source,
+			Target: edge.Target,
+			Weight: edge.Weight,
+			Label:  edge.Label,
+		})
+	}
+	return writeXML(w, doc)
+}
+
+// WriteCSV writes the edges of the graph as a CSV edge list with a
+// Source,Target,Weight,Label header
+func (g *Graph) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"Source", "Target", "Weight", "Label"}); err != nil {
+		return err
+	}
+	for _, edge := range g.Edges {
+		record := []string{edge.Source, edge.Target, strconv.Itoa(edge.Weight), edge.Label}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
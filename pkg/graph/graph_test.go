@@ -0,0 +1,68 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func testGraph() *Graph {
+	g := New()
+	g.AddEdge("a.onion", "b.onion", 3, "market")
+	g.AddEdge("b.onion", "a.onion", 1, "")
+	g.AddEdge("a.onion", "c.onion", 2, "forum & chat")
+	return g
+}
+
+func TestNodes(t *testing.T) {
+	nodes := testGraph().Nodes()
+	if strings.Join(nodes, ",") != "a.onion,b.onion,c.onion" {
+		t.Errorf("unexpected nodes %v", nodes)
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testGraph().WriteGraphML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc graphml
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Graph.Nodes) != 3 || len(doc.Graph.Edges) != 3 {
+		t.Errorf("should have 3 nodes and 3 edges, got %d and %d", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+	if doc.Graph.Edges[2].Data[1].Value != "forum & chat" {
+		t.Errorf("unexpected anchor %q", doc.Graph.Edges[2].Data[1].Value)
+	}
+}
+
+func TestWriteGEXF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testGraph().WriteGEXF(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc gexf
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Graph.Nodes) != 3 || len(doc.Graph.Edges) != 3 {
+		t.Errorf("should have 3 nodes and 3 edges, got %d and %d", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+	if doc.Graph.Edges[0].Weight != 3 {
+		t.Errorf("should have weight 3, got %d", doc.Graph.Edges[0].Weight)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testGraph().WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "Source,Target,Weight,Label\na.onion,b.onion,3,market\nb.onion,a.onion,1,\na.onion,c.onion,2,forum & chat\n"
+	if buf.String() != want {
+		t.Errorf("unexpected csv:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,138 @@
+package graph
+
+import "math"
+
+// adjacency returns the weighted outbound edges of every node, by node index
+func (g *Graph) adjacency() [][]Edge {
+	out := make([][]Edge, len(g.order))
+	for _, edge := range g.Edges {
+		if edge.Weight <= 0 {
+			continue
+		}
+		i := g.nodes[edge.Source]
+		out[i] = append(out[i], edge)
+	}
+	return out
+}
+
+// PageRank computes the weighted PageRank of every node. The ranks sum up to
+// one; the rank of dangling nodes is spread over the whole graph.
+func (g *Graph) PageRank(damping float64, iterations int) map[string]float64 {
+	n := len(g.order)
+	ranks := make(map[string]float64, n)
+	if n == 0 {
+		return ranks
+	}
+
+	out := g.adjacency()
+	totals := make([]float64, n)
+	for i, edges := range out {
+		for _, edge := range edges {
+			totals[i] += float64(edge.Weight)
+		}
+	}
+
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+	next := make([]float64, n)
+	for it := 0; it < iterations; it++ {
+		dangling := 0.0
+		for i := range rank {
+			if totals[i] == 0 {
+				dangling += rank[i]
+			}
+		}
+		base := (1-damping)/float64(n) + damping*dangling/float64(n)
+		for i := range next {
+			next[i] = base
+		}
+		for i, edges := range out {
+			for _, edge := range edges {
+				j := g.nodes[edge.Target]
+				next[j] += damping * rank[i] * float64(edge.Weight) / totals[i]
+			}
+		}
+
+		delta := 0.0
+		for i := range rank {
+			delta += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if delta < 1e-9 {
+			break
+		}
+	}
+
+	for i, id := range g.order {
+		ranks[id] = rank[i]
+	}
+	return ranks
+}
+
+// HITS computes the hub and authority scores of every node, each normalized
+// to a unit euclidean norm
+func (g *Graph) HITS(iterations int) (map[string]float64, map[string]float64) {
+	n := len(g.order)
+	hubs := make(map[string]float64, n)
+	authorities := make(map[string]float64, n)
+	if n == 0 {
+		return hubs, authorities
+	}
+
+	out := g.adjacency()
+	hub := make([]float64, n)
+	auth := make([]float64, n)
+	for i := range hub {
+		hub[i] = 1
+	}
+	for it := 0; it < iterations; it++ {
+		for i := range auth {
+			auth[i] = 0
+		}
+		for i, edges := range out {
+			for _, edge := range edges {
+				auth[g.nodes[edge.Target]] += hub[i] * float64(edge.Weight)
+			}
+		}
+		normalize(auth)
+
+		previous := append([]float64(nil), hub...)
+		for i, edges := range out {
+			hub[i] = 0
+			for _, edge := range edges {
+				hub[i] += auth[g.nodes[edge.Target]] * float64(edge.Weight)
+			}
+		}
+		normalize(hub)
+
+		delta := 0.0
+		for i := range hub {
+			delta += math.Abs(hub[i] - previous[i])
+		}
+		if delta < 1e-9 {
+			break
+		}
+	}
+
+	for i, id := range g.order {
+		hubs[id] = hub[i]
+		authorities[id] = auth[i]
+	}
+	return hubs, authorities
+}
+
+func normalize(values []float64) {
+	norm := 0.0
+	for _, v := range values {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return
+	}
+	for i := range values {
+		values[i] /= norm
+	}
+}
@@ -0,0 +1,50 @@
+package graph
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPageRank(t *testing.T) {
+	g := New()
+	g.AddEdge("a", "c", 1, "")
+	g.AddEdge("b", "c", 1, "")
+	g.AddEdge("c", "a", 1, "")
+	g.AddNode("d")
+
+	ranks := g.PageRank(0.85, 100)
+	sum := 0.0
+	for _, rank := range ranks {
+		sum += rank
+	}
+	if math.Abs(sum-1) > 1e-6 {
+		t.Errorf("ranks should sum up to 1, got %f", sum)
+	}
+	if !(ranks["c"] > ranks["a"] && ranks["a"] > ranks["b"]) {
+		t.Errorf("unexpected ranks order %v", ranks)
+	}
+	if math.Abs(ranks["b"]-ranks["d"]) > 1e-9 {
+		t.Errorf("b and d should have the same rank, got %v", ranks)
+	}
+}
+
+func TestPageRankEmpty(t *testing.T) {
+	if ranks := New().PageRank(0.85, 10); len(ranks) != 0 {
+		t.Errorf("should be empty, got %v", ranks)
+	}
+}
+
+func TestHITS(t *testing.T) {
+	g := New()
+	g.AddEdge("hub", "x", 1, "")
+	g.AddEdge("hub", "y", 1, "")
+	g.AddEdge("other", "x", 1, "")
+
+	hubs, authorities := g.HITS(100)
+	if !(hubs["hub"] > hubs["other"] && hubs["x"] == 0) {
+		t.Errorf("unexpected hubs %v", hubs)
+	}
+	if !(authorities["x"] > authorities["y"] && authorities["hub"] == 0) {
+		t.Errorf("unexpected authorities %v", authorities)
+	}
+}
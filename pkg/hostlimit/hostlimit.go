@@ -0,0 +1,240 @@
+// Package hostlimit enforces per-host politeness: a maximum number of
+// concurrent requests, a minimum delay between two requests and an automatic
+// slowdown of the hosts that start rate limiting or timing out.
+package hostlimit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule is the politeness policy of the hosts matching Pattern
+type Rule struct {
+	// Pattern is a shell glob matched against the host, like `*.onion`
+	Pattern string
+	// Concurrency is the maximum number of concurrent requests to a host
+	Concurrency int
+	// Delay is the minimum delay between the start of two requests to a host
+	Delay time.Duration
+	// MaxDelay caps the delay of a host which is being slowed down, 5 minutes
+	// by default
+	MaxDelay time.Duration
+}
+
+type host struct {
+	rule    Rule
+	active  int
+	delay   time.Duration
+	next    time.Time
+	release chan struct{}
+}
+
+// Limiter is a spider-wide scheduler of the requests to each host
+type Limiter struct {
+	Default Rule
+	Rules   []Rule
+
+	hosts map[string]*host
+	mu    sync.Mutex
+}
+
+// New returns a limiter applying the default rule to the hosts which do not
+// match any of the rules
+func New(defaultRule Rule, rules ...Rule) *Limiter {
+	return &Limiter{
+		Default: defaultRule,
+		Rules:   rules,
+		hosts:   make(map[string]*host),
+	}
+}
+
+// rule returns the first rule matching name
+func (l *Limiter) rule(name string) Rule {
+	for _, rule := range l.Rules {
+		if ok, _ := path.Match(rule.Pattern, name); ok {
+			return rule
+		}
+	}
+	return l.Default
+}
+
+// host returns the state of a host, creating it if needed. It must be called
+// with the lock held.
+func (l *Limiter) host(name string) *host {
+	h, ok := l.hosts[name]
+	if !ok {
+		rule := l.rule(name)
+		if rule.Concurrency < 1 {
+			rule.Concurrency = 1
+		}
+		if rule.MaxDelay == 0 {
+			rule.MaxDelay = 5 * time.Minute
+		}
+		if rule.MaxDelay < rule.Delay {
+			rule.MaxDelay = rule.Delay
+		}
+		h = &host{
+			rule:    rule,
+			delay:   rule.Delay,
+			release: make(chan struct{}),
+		}
+		l.hosts[name] = h
+	}
+	return h
+}
+
+// Acquire waits until a request to name is allowed or ctx is done. Every
+// successful Acquire must be followed by a Release.
+func (l *Limiter) Acquire(ctx context.Context, name string) error {
+	for {
+		l.mu.Lock()
+		h := l.host(name)
+		now := time.Now()
+		if h.active < h.rule.Concurrency && !now.Before(h.next) {
+			h.active++
+			h.next = now.Add(h.delay)
+			l.mu.Unlock()
+			return nil
+		}
+
+		// wait for a running request to end or, if the host has free slots,
+		// for its delay to expire
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if h.active < h.rule.Concurrency {
+			timer = time.NewTimer(h.next.Sub(now))
+			expired = timer.C
+		}
+		release := h.release
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return ctx.Err()
+		case <-release:
+		case <-expired:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Release ends a request to name. When slowDown is true the delay of the host
+// is doubled, otherwise it decays back towards the delay of its rule.
+func (l *Limiter) Release(name string, slowDown bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h := l.host(name)
+	if h.active > 0 {
+		h.active--
+	}
+
+	if slowDown {
+		if h.delay < time.Second {
+			h.delay = time.Second
+		} else {
+			h.delay *= 2
+		}
+		if h.delay > h.rule.MaxDelay {
+			h.delay = h.rule.MaxDelay
+		}
+		h.next = time.Now().Add(h.delay)
+	} else if h.delay > h.rule.Delay {
+		h.delay -= (h.delay - h.rule.Delay) / 4
+		if h.delay-h.rule.Delay < time.Millisecond {
+			h.delay = h.rule.Delay
+		}
+	}
+
+	close(h.release)
+	h.release = make(chan struct{})
+}
+
+// Defer postpones the next request to name by at least d, as asked by a
+// Retry-After header
+func (l *Limiter) Defer(name string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h := l.host(name)
+	if d > h.rule.MaxDelay {
+		d = h.rule.MaxDelay
+	}
+	if next := time.Now().Add(d); next.After(h.next) {
+		h.next = next
+	}
+}
+
+// SetDelay raises the minimum delay between two requests to name to d, as
+// asked by a robots.txt Crawl-delay
+func (l *Limiter) SetDelay(name string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h := l.host(name)
+	if d <= h.rule.Delay {
+		return
+	}
+	h.rule.Delay = d
+	if h.rule.MaxDelay < d {
+		h.rule.MaxDelay = d
+	}
+	if h.delay < d {
+		h.delay = d
+	}
+}
+
+// Delay returns the current delay between two requests to name
+func (l *Limiter) Delay(name string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.host(name).delay
+}
+
+// ParseRules reads the rules from r, one per line, in the form
+//
+//	pattern concurrency delay [maxdelay]
+//
+// like `*.onion 2 5s 5m`. Empty lines and lines starting with # are skipped.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("hostlimit: line %d: expected pattern concurrency delay [maxdelay]", line)
+		}
+		if _, err := path.Match(fields[0], ""); err != nil {
+			return nil, fmt.Errorf("hostlimit: line %d: %v", line, err)
+		}
+		concurrency, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("hostlimit: line %d: %v", line, err)
+		}
+		delay, err := time.ParseDuration(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("hostlimit: line %d: %v", line, err)
+		}
+		rule := Rule{Pattern: fields[0], Concurrency: concurrency, Delay: delay}
+		if len(fields) == 4 {
+			if rule.MaxDelay, err = time.ParseDuration(fields[3]); err != nil {
+				return nil, fmt.Errorf("hostlimit: line %d: %v", line, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
@@ -0,0 +1,131 @@
+package hostlimit
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrency(t *testing.T) {
+	l := New(Rule{Concurrency: 2})
+	var mu sync.Mutex
+	active, peak := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.Acquire(context.Background(), "a.onion"); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			active++
+			if active > peak {
+				peak = active
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			l.Release("a.onion", false)
+		}()
+	}
+	wg.Wait()
+	if peak != 2 {
+		t.Errorf("should run at most 2 concurrent requests, got %d", peak)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	l := New(Rule{Concurrency: 5, Delay: 20 * time.Millisecond})
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.Acquire(context.Background(), "a.onion"); err != nil {
+			t.Fatal(err)
+		}
+		l.Release("a.onion", false)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("requests should be spaced by the delay, took %v", elapsed)
+	}
+
+	// other hosts are not delayed
+	start = time.Now()
+	l.Acquire(context.Background(), "b.onion")
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("other hosts should not wait, took %v", elapsed)
+	}
+
+	// the delay can only be raised
+	l.SetDelay("b.onion", time.Millisecond)
+	if d := l.Delay("b.onion"); d != 20*time.Millisecond {
+		t.Errorf("delay should stay 20ms, got %v", d)
+	}
+	l.SetDelay("b.onion", time.Second)
+	if d := l.Delay("b.onion"); d != time.Second {
+		t.Errorf("delay should be raised to 1s, got %v", d)
+	}
+}
+
+func TestSlowDown(t *testing.T) {
+	l := New(Rule{Concurrency: 1, MaxDelay: 3 * time.Second})
+	for i := 0; i < 3; i++ {
+		l.Acquire(context.Background(), "a.onion")
+		l.Release("a.onion", true)
+		l.hosts["a.onion"].next = time.Time{}
+	}
+	if d := l.Delay("a.onion"); d != 3*time.Second {
+		t.Errorf("delay should be capped to 3s, got %v", d)
+	}
+	for i := 0; i < 50; i++ {
+		l.Acquire(context.Background(), "a.onion")
+		l.Release("a.onion", false)
+		l.hosts["a.onion"].next = time.Time{}
+	}
+	if d := l.Delay("a.onion"); d != 0 {
+		t.Errorf("delay should decay back to 0, got %v", d)
+	}
+}
+
+func TestAcquireCancel(t *testing.T) {
+	l := New(Rule{Concurrency: 1})
+	l.Acquire(context.Background(), "a.onion")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(ctx, "a.onion"); err != context.DeadlineExceeded {
+		t.Errorf("should give up when the context is done, got %v", err)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`
+# slow services
+*.onion 1 5s 10m
+bigmarket*.onion 4 500ms
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("should parse 2 rules, got %d", len(rules))
+	}
+	if rules[0] != (Rule{Pattern: "*.onion", Concurrency: 1, Delay: 5 * time.Second, MaxDelay: 10 * time.Minute}) {
+		t.Errorf("unexpected rule %+v", rules[0])
+	}
+
+	l := New(Rule{Concurrency: 2}, Rule{Pattern: "bigmarket*.onion", Concurrency: 4}, rules[0])
+	if c := l.rule("bigmarketxyz.onion").Concurrency; c != 4 {
+		t.Errorf("the first matching rule should be used, got concurrency %d", c)
+	}
+	if c := l.rule("example.com").Concurrency; c != 2 {
+		t.Errorf("the default rule should be used, got concurrency %d", c)
+	}
+
+	if _, err := ParseRules(strings.NewReader("*.onion two 5s")); err == nil {
+		t.Error("should fail on invalid concurrency")
+	}
+}
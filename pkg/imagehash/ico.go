@@ -0,0 +1,119 @@
+package imagehash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/image/bmp"
+)
+
+// ErrInvalidICO is returned for malformed ICO files
+var ErrInvalidICO = errors.New("imagehash: invalid ico")
+
+// icoEntry is an image of the directory of an ICO file
+type icoEntry struct {
+	Width, Height uint8
+	Colors        uint8
+	Reserved      uint8
+	Planes        uint16
+	BitCount      uint16
+	Size          uint32
+	Offset        uint32
+}
+
+func init() {
+	image.RegisterFormat("ico", "\x00\x00\x01\x00", decodeICO, decodeICOConfig)
+}
+
+// largestICOEntry returns the data of the largest image of an ICO file
+func largestICOEntry(data []byte) ([]byte, error) {
+	if len(data) < 6 {
+		return nil, ErrInvalidICO
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	if count == 0 {
+		return nil, ErrInvalidICO
+	}
+	var best *icoEntry
+	bestSize := -1
+	r := bytes.NewReader(data[6:])
+	for i := 0; i < count; i++ {
+		var entry icoEntry
+		if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+			return nil, ErrInvalidICO
+		}
+		// a size of 0 means 256 pixels
+		size := int(entry.Width)
+		if size == 0 {
+			size = 256
+		}
+		if size > bestSize {
+			best, bestSize = &entry, size
+		}
+	}
+	end := uint64(best.Offset) + uint64(best.Size)
+	if end > uint64(len(data)) || best.Size < 40 {
+		return nil, ErrInvalidICO
+	}
+	return data[best.Offset:end], nil
+}
+
+// dibToBMP turns the bitmap of an ICO file into a BMP file. The height of the
+// bitmap counts both the image and its transparency mask, which is dropped.
+func dibToBMP(dib []byte) []byte {
+	header := make([]byte, len(dib))
+	copy(header, dib)
+	height := int32(binary.LittleEndian.Uint32(header[8:12]))
+	binary.LittleEndian.PutUint32(header[8:12], uint32(height/2))
+
+	headerSize := binary.LittleEndian.Uint32(header[0:4])
+	bitCount := binary.LittleEndian.Uint16(header[14:16])
+	colors := binary.LittleEndian.Uint32(header[32:36])
+	if colors == 0 && bitCount <= 8 {
+		colors = 1 << bitCount
+	}
+	offset := 14 + headerSize + colors*4
+
+	file := make([]byte, 14, 14+len(header))
+	file[0], file[1] = 'B', 'M'
+	binary.LittleEndian.PutUint32(file[2:6], uint32(14+len(header)))
+	binary.LittleEndian.PutUint32(file[10:14], offset)
+	return append(file, header...)
+}
+
+func decodeICOEntry(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := largestICOEntry(data)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(entry, []byte("\x89PNG")) {
+		img, _, err := image.Decode(bytes.NewReader(entry))
+		return img, err
+	}
+	return bmp.Decode(bytes.NewReader(dibToBMP(entry)))
+}
+
+func decodeICO(r io.Reader) (image.Image, error) {
+	return decodeICOEntry(r)
+}
+
+func decodeICOConfig(r io.Reader) (image.Config, error) {
+	img, err := decodeICOEntry(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: color.RGBAModel,
+		Width:      img.Bounds().Dx(),
+		Height:     img.Bounds().Dy(),
+	}, nil
+}
@@ -0,0 +1,217 @@
+// Package imagehash fingerprints images: the MD5 and Shodan-style mmh3 hashes
+// of favicons, which only match identical files, and the aHash, dHash and
+// pHash perceptual hashes, which stay close for visually similar images.
+package imagehash
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+
+	// image formats found on web pages
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+// MD5 returns the hex encoded MD5 of data
+func MD5(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// MMH3 returns the favicon hash used by Shodan: the 32 bits murmur3 of the
+// base64 encoding of data, wrapped at 76 characters
+func MMH3(data []byte) int32 {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteByte('\n')
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteByte('\n')
+	return int32(murmur3(buf.Bytes(), 0))
+}
+
+// murmur3 returns the 32 bits MurmurHash3 of data
+func murmur3(data []byte, seed uint32) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	h := seed
+	n := len(data) / 4 * 4
+	for i := 0; i < n; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	var k uint32
+	switch len(data) & 3 {
+	case 3:
+		k ^= uint32(data[n+2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[n+1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[n])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// Decode decodes a PNG, JPEG, GIF, BMP, WebP or ICO image
+func Decode(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// Distance returns the Hamming distance between two perceptual hashes
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// grayscale returns the luminance of img scaled down to width x height, each
+// value being the average of the pixels of its area
+func grayscale(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	result := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			if x1 == x0 {
+				x1++
+			}
+			var sum float64
+			for py := y0; py < y1; py++ {
+				for px := x0; px < x1; px++ {
+					r, g, b, a := img.At(px, py).RGBA()
+					// transparent pixels are drawn on a white background
+					white := float64(0xffff - a)
+					sum += 0.299*(float64(r)+white) + 0.587*(float64(g)+white) + 0.114*(float64(b)+white)
+				}
+			}
+			result[y*width+x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return result
+}
+
+// AHash returns the average hash of img: whether each pixel of its 8x8
+// grayscale thumbnail is brighter than the mean
+func AHash(img image.Image) uint64 {
+	pixels := grayscale(img, 8, 8)
+	var mean float64
+	for _, p := range pixels {
+		mean += p
+	}
+	mean /= float64(len(pixels))
+	var hash uint64
+	for i, p := range pixels {
+		if p > mean {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// DHash returns the difference hash of img: whether each pixel of its 9x8
+// grayscale thumbnail is brighter than its right neighbour
+func DHash(img image.Image) uint64 {
+	pixels := grayscale(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if pixels[y*9+x] > pixels[y*9+x+1] {
+				hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return hash
+}
+
+// dct returns the 2D DCT-II of a size x size matrix
+func dct(pixels []float64, size int) []float64 {
+	cos := make([]float64, size*size)
+	for u := 0; u < size; u++ {
+		for x := 0; x < size; x++ {
+			cos[u*size+x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*size))
+		}
+	}
+	rows := make([]float64, size*size)
+	for y := 0; y < size; y++ {
+		for u := 0; u < size; u++ {
+			var sum float64
+			for x := 0; x < size; x++ {
+				sum += pixels[y*size+x] * cos[u*size+x]
+			}
+			rows[y*size+u] = sum
+		}
+	}
+	result := make([]float64, size*size)
+	for u := 0; u < size; u++ {
+		for v := 0; v < size; v++ {
+			var sum float64
+			for y := 0; y < size; y++ {
+				sum += rows[y*size+u] * cos[v*size+y]
+			}
+			result[v*size+u] = sum
+		}
+	}
+	return result
+}
+
+// PHash returns the perceptual hash of img: whether each of the 8x8 lowest
+// frequencies of the DCT of its 32x32 grayscale thumbnail is above their
+// median
+func PHash(img image.Image) uint64 {
+	const size = 32
+	coefficients := dct(grayscale(img, size, size), size)
+	low := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			low = append(low, coefficients[v*size+u])
+		}
+	}
+	// the first coefficient is the average brightness, left out of the median
+	sorted := append([]float64(nil), low[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	var hash uint64
+	for i, c := range low {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
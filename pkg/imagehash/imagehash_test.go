@@ -0,0 +1,148 @@
+package imagehash
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestMurmur3(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected uint32
+	}{
+		{"", 0},
+		{"hello", 0x248bfa47},
+		{"The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	}
+	for _, test := range tests {
+		if got := murmur3([]byte(test.data), 0); got != test.expected {
+			t.Errorf("%q: expected %x, got %x", test.data, test.expected, got)
+		}
+	}
+}
+
+func TestMMH3(t *testing.T) {
+	data := bytes.Repeat([]byte{0xde, 0xad, 0xbe, 0xef}, 25)
+	encoded := base64.StdEncoding.EncodeToString(data)
+	wrapped := encoded[:76] + "\n" + encoded[76:] + "\n"
+	if got, expected := MMH3(data), int32(murmur3([]byte(wrapped), 0)); got != expected {
+		t.Errorf("expected %d, got %d", expected, got)
+	}
+	if MD5([]byte("hello")) != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("unexpected md5 %s", MD5([]byte("hello")))
+	}
+}
+
+// testImage returns a logo-like image: a dark disc on a light gradient
+func testImage(size int, invert bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	center := size / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			v := uint8(128 + 127*x/size)
+			dx, dy := x-center, y-center
+			if dx*dx+dy*dy < size*size/9 {
+				v = uint8(20 + 40*y/size)
+			}
+			if invert {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHashes(t *testing.T) {
+	original := testImage(256, false)
+	scaled := testImage(64, false)
+	inverted := testImage(256, true)
+
+	hashes := []struct {
+		name string
+		hash func(image.Image) uint64
+	}{
+		{"ahash", AHash},
+		{"dhash", DHash},
+		{"phash", PHash},
+	}
+	for _, h := range hashes {
+		if d := Distance(h.hash(original), h.hash(scaled)); d > 6 {
+			t.Errorf("%s: a scaled image should be close, got %d", h.name, d)
+		}
+		if d := Distance(h.hash(original), h.hash(inverted)); d < 20 {
+			t.Errorf("%s: an inverted image should be far, got %d", h.name, d)
+		}
+	}
+}
+
+// testICO builds an ICO file holding the given images
+func testICO(entries ...[]byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint16{0, 1, uint16(len(entries))})
+	offset := 6 + 16*len(entries)
+	for i, entry := range entries {
+		binary.Write(&buf, binary.LittleEndian, icoEntry{
+			Width:    uint8(16 * (i + 1)),
+			Height:   uint8(16 * (i + 1)),
+			Planes:   1,
+			BitCount: 32,
+			Size:     uint32(len(entry)),
+			Offset:   uint32(offset),
+		})
+		offset += len(entry)
+	}
+	for _, entry := range entries {
+		buf.Write(entry)
+	}
+	return buf.Bytes()
+}
+
+// testDIB returns a 32 bits bitmap as stored in ICO files, with its mask
+func testDIB(size int, c color.RGBA) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []int32{40, int32(size), int32(size * 2)})
+	binary.Write(&buf, binary.LittleEndian, []uint16{1, 32})
+	binary.Write(&buf, binary.LittleEndian, make([]uint32, 6))
+	for i := 0; i < size*size; i++ {
+		buf.Write([]byte{c.B, c.G, c.R, c.A})
+	}
+	// transparency mask, rows padded to 4 bytes
+	buf.Write(make([]byte, size*4))
+	return buf.Bytes()
+}
+
+func TestDecodeICO(t *testing.T) {
+	var embedded bytes.Buffer
+	if err := png.Encode(&embedded, testImage(32, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Decode(testICO(testDIB(16, color.RGBA{255, 0, 0, 255}), embedded.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 32 {
+		t.Errorf("the largest image should be decoded, got %v", img.Bounds())
+	}
+
+	img, err = Decode(testICO(testDIB(16, color.RGBA{255, 0, 0, 255})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 16 {
+		t.Errorf("the mask should be dropped, got %v", img.Bounds())
+	}
+	if r, g, _, _ := img.At(8, 8).RGBA(); r>>8 != 255 || g != 0 {
+		t.Errorf("expected a red pixel, got %v", img.At(8, 8))
+	}
+
+	if _, err := Decode([]byte("\x00\x00\x01\x00\x00\x00")); err == nil {
+		t.Errorf("should fail on an empty directory")
+	}
+}
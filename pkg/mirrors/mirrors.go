@@ -0,0 +1,267 @@
+// Package mirrors groups the domains serving the same site, and flags the
+// clones whose payment addresses or keys differ from the rest of their group,
+// which is the usual sign of a phishing clone.
+package mirrors
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/samirettali/tor-spider/pkg/simhash"
+)
+
+// Site is the home page of a domain
+type Site struct {
+	Domain  string
+	Title   string
+	SimHash uint64
+	// Favicon is the hash of the favicon of the site
+	Favicon string
+	// Technologies are the applications detected on the site
+	Technologies []string
+	// Paths are the internal links of the home page
+	Paths []string
+	// Identifiers are the identifiers published by the site, like crypto
+	// addresses or PGP fingerprints, by type
+	Identifiers map[string][]string
+}
+
+// Options tune the detection
+type Options struct {
+	// MaxDistance is the maximum SimHash distance between the home pages of
+	// mirrors
+	MaxDistance int
+	// MinPathSimilarity is the minimum Jaccard similarity of the internal links
+	// of mirrors having the same title and technologies
+	MinPathSimilarity float64
+	// MinPaths is the minimum number of internal links of a site to compare
+	// its structure
+	MinPaths int
+}
+
+// DefaultOptions are the options used when none are given
+var DefaultOptions = Options{
+	MaxDistance:       3,
+	MinPathSimilarity: 0.6,
+	MinPaths:          3,
+}
+
+// Cluster is a group of domains serving the same site
+type Cluster struct {
+	Title   string
+	Domains []string
+	// Clones are the domains whose identifiers differ from the majority of
+	// the cluster, with the types of the differing identifiers
+	Clones map[string][]string
+}
+
+// unionFind is a disjoint set of site indexes
+type unionFind []int
+
+func (u unionFind) find(i int) int {
+	for u[i] != i {
+		u[i] = u[u[i]]
+		i = u[i]
+	}
+	return i
+}
+
+func (u unionFind) union(i, j int) {
+	u[u.find(i)] = u.find(j)
+}
+
+func normalizeTitle(title string) string {
+	return strings.Join(strings.Fields(strings.ToLower(title)), " ")
+}
+
+// pathSimilarity returns the Jaccard similarity of two sets of paths
+func pathSimilarity(a, b []string) float64 {
+	set := make(map[string]bool, len(a))
+	for _, path := range a {
+		set[path] = true
+	}
+	union := len(set)
+	common := 0
+	seen := make(map[string]bool, len(b))
+	for _, path := range b {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		if set[path] {
+			common++
+		} else {
+			union++
+		}
+	}
+	if union == 0 {
+		return 0
+	}
+	return float64(common) / float64(union)
+}
+
+func sameTechnologies(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		// missing analyses do not prevent a match
+		return true
+	}
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameStructure tells whether two sites with the same title look the same
+func sameStructure(a, b *Site, opts Options) bool {
+	if a.Favicon != "" && a.Favicon == b.Favicon {
+		return true
+	}
+	if len(a.Paths) < opts.MinPaths || len(b.Paths) < opts.MinPaths {
+		return false
+	}
+	return sameTechnologies(a.Technologies, b.Technologies) &&
+		pathSimilarity(a.Paths, b.Paths) >= opts.MinPathSimilarity
+}
+
+// Detect returns the clusters of at least two domains. Two sites are mirrors
+// when their home pages are near duplicates, or when they share the title and
+// either the favicon or the technologies and most of the internal links.
+func Detect(sites []Site, opts Options) []Cluster {
+	sets := make(unionFind, len(sites))
+	for i := range sets {
+		sets[i] = i
+	}
+
+	index := simhash.NewIndex(opts.MaxDistance)
+	byTitle := make(map[string][]int)
+	for i := range sites {
+		site := &sites[i]
+		if site.SimHash != 0 {
+			for _, match := range index.Search(site.SimHash) {
+				sets.union(i, int(match.ID))
+			}
+			index.Add(uint(i), site.SimHash)
+		}
+		if title := normalizeTitle(site.Title); title != "" {
+			for _, j := range byTitle[title] {
+				if sets.find(i) != sets.find(j) && sameStructure(site, &sites[j], opts) {
+					sets.union(i, j)
+				}
+			}
+			byTitle[title] = append(byTitle[title], i)
+		}
+	}
+
+	groups := make(map[int][]int)
+	for i := range sites {
+		root := sets.find(i)
+		groups[root] = append(groups[root], i)
+	}
+	var clusters []Cluster
+	for _, members := range groups {
+		if len(members) < 2 {
+			continue
+		}
+		clusters = append(clusters, newCluster(sites, members))
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i].Domains) != len(clusters[j].Domains) {
+			return len(clusters[i].Domains) > len(clusters[j].Domains)
+		}
+		return clusters[i].Domains[0] < clusters[j].Domains[0]
+	})
+	return clusters
+}
+
+func newCluster(sites []Site, members []int) Cluster {
+	cluster := Cluster{Clones: make(map[string][]string)}
+	titles := make(map[string]int)
+	types := make(map[string]bool)
+	for _, i := range members {
+		cluster.Domains = append(cluster.Domains, sites[i].Domain)
+		if title := strings.TrimSpace(sites[i].Title); title != "" {
+			titles[title]++
+		}
+		for name := range sites[i].Identifiers {
+			types[name] = true
+		}
+	}
+	sort.Strings(cluster.Domains)
+	for title, count := range titles {
+		if count > titles[cluster.Title] || (count == titles[cluster.Title] && title < cluster.Title) {
+			cluster.Title = title
+		}
+	}
+
+	var names []string
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, domain := range clones(sites, members, name) {
+			cluster.Clones[domain] = append(cluster.Clones[domain], name)
+		}
+	}
+	return cluster
+}
+
+// clones returns the domains publishing identifiers of the given type of
+// which none is published by the majority of the domains of the cluster
+// publishing this type
+func clones(sites []Site, members []int, name string) []string {
+	counts := make(map[string]int)
+	publishers := 0
+	for _, i := range members {
+		values := sites[i].Identifiers[name]
+		if len(values) == 0 {
+			continue
+		}
+		publishers++
+		seen := make(map[string]bool)
+		for _, value := range values {
+			if !seen[value] {
+				seen[value] = true
+				counts[value]++
+			}
+		}
+	}
+
+	majority := make(map[string]bool)
+	for value, count := range counts {
+		if count*2 > publishers {
+			majority[value] = true
+		}
+	}
+	if len(majority) == 0 {
+		return nil
+	}
+
+	var result []string
+	for _, i := range members {
+		values := sites[i].Identifiers[name]
+		if len(values) == 0 {
+			continue
+		}
+		legit := false
+		for _, value := range values {
+			if majority[value] {
+				legit = true
+				break
+			}
+		}
+		if !legit {
+			result = append(result, sites[i].Domain)
+		}
+	}
+	return result
+}
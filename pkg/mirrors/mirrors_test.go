@@ -0,0 +1,116 @@
+package mirrors
+
+import (
+	"reflect"
+	"testing"
+)
+
+var marketPaths = []string{"/", "/login", "/register", "/listings", "/vendors", "/faq"}
+
+func testSites() []Site {
+	return []Site{
+		{
+			Domain:       "market1",
+			Title:        "Dark Market",
+			Favicon:      "4bd0c6e6b8a1d8d2b5e0c4f6a9b1c2d3",
+			SimHash:      0xf0f0f0f0f0f0f0f0,
+			Technologies: []string{"Nginx", "PHP"},
+			Paths:        marketPaths,
+			Identifiers: map[string][]string{
+				"bitcoin": {"1BoatSLRHtKNngkdXEeobR76b53LETtpyT"},
+				"pgp":     {"BE68474AA3DF0390D4D0B57747726AEC2892CD2E"},
+			},
+		},
+		{
+			// near duplicate home page
+			Domain:       "market2",
+			Title:        "Dark Market",
+			SimHash:      0xf0f0f0f0f0f0f0f1,
+			Technologies: []string{"Nginx", "PHP"},
+			Paths:        marketPaths,
+			Identifiers: map[string][]string{
+				"bitcoin": {"1BoatSLRHtKNngkdXEeobR76b53LETtpyT"},
+				"pgp":     {"BE68474AA3DF0390D4D0B57747726AEC2892CD2E"},
+			},
+		},
+		{
+			// same title and structure, different wallet
+			Domain:       "market3",
+			Title:        "  dark   MARKET ",
+			SimHash:      0x0f0f0f0f0f0f0f0f,
+			Technologies: []string{"PHP", "Nginx"},
+			Paths:        append([]string{"/news"}, marketPaths...),
+			Identifiers: map[string][]string{
+				"bitcoin": {"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy"},
+				"pgp":     {"BE68474AA3DF0390D4D0B57747726AEC2892CD2E"},
+			},
+		},
+		{
+			// same title, other technologies
+			Domain:       "market4",
+			Title:        "Dark Market",
+			SimHash:      0xaaaaaaaaaaaaaaaa,
+			Technologies: []string{"Apache"},
+			Paths:        marketPaths,
+		},
+		{
+			// same title and favicon
+			Domain:  "market5",
+			Title:   "Dark Market",
+			Favicon: "4bd0c6e6b8a1d8d2b5e0c4f6a9b1c2d3",
+		},
+		{
+			Domain:  "forum",
+			Title:   "Forum",
+			SimHash: 0x5555555555555555,
+			Paths:   []string{"/", "/threads"},
+		},
+	}
+}
+
+func TestDetect(t *testing.T) {
+	clusters := Detect(testSites(), DefaultOptions)
+	if len(clusters) != 1 {
+		t.Fatalf("expected 1 cluster, got %v", clusters)
+	}
+	cluster := clusters[0]
+	if !reflect.DeepEqual(cluster.Domains, []string{"market1", "market2", "market3", "market5"}) {
+		t.Errorf("unexpected domains %v", cluster.Domains)
+	}
+	if cluster.Title != "Dark Market" {
+		t.Errorf("expected the most common title, got %q", cluster.Title)
+	}
+	expected := map[string][]string{"market3": {"bitcoin"}}
+	if !reflect.DeepEqual(cluster.Clones, expected) {
+		t.Errorf("expected clones %v, got %v", expected, cluster.Clones)
+	}
+}
+
+func TestNoMajority(t *testing.T) {
+	sites := testSites()[1:3]
+	sites[0].SimHash = sites[1].SimHash
+	clusters := Detect(sites, DefaultOptions)
+	if len(clusters) != 1 {
+		t.Fatalf("expected 1 cluster, got %v", clusters)
+	}
+	if len(clusters[0].Clones) != 0 {
+		t.Errorf("two domains disagreeing have no majority, got %v", clusters[0].Clones)
+	}
+}
+
+func TestPathSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b     []string
+		expected float64
+	}{
+		{[]string{"/a", "/b"}, []string{"/a", "/b"}, 1},
+		{[]string{"/a", "/b"}, []string{"/b", "/c"}, 1.0 / 3},
+		{[]string{"/a"}, []string{"/b"}, 0},
+		{nil, nil, 0},
+	}
+	for _, test := range tests {
+		if got := pathSimilarity(test.a, test.b); got != test.expected {
+			t.Errorf("%v %v: expected %v, got %v", test.a, test.b, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Package proxypool spreads requests over several Tor SOCKS or HTTP proxies,
+// health-checks them and asks Tor for new circuits when they start failing.
+package proxypool
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Strategy is the way a proxy is chosen for a request
+type Strategy int
+
+const (
+	// RoundRobin uses every healthy proxy in turn
+	RoundRobin Strategy = iota
+	// PinHost always uses the same proxy for the same host, as long as the
+	// set of healthy proxies does not change
+	PinHost
+)
+
+// ErrNoHealthyProxy is returned when every proxy of the pool is unhealthy
+var ErrNoHealthyProxy = errors.New("proxypool: no healthy proxy")
+
+// Endpoint is a proxy of the pool, optionally paired with the control port of
+// its Tor daemon
+type Endpoint struct {
+	URL             *url.URL
+	ControlAddr     string
+	ControlPassword string
+	healthy         bool
+	failures        int
+}
+
+// Healthy reports whether the endpoint passed its last health check
+func (e *Endpoint) Healthy() bool {
+	return e.healthy
+}
+
+func (e *Endpoint) isSocks() bool {
+	return e.URL.Scheme == "socks5" || e.URL.Scheme == "socks5h"
+}
+
+// Pool is a set of proxies
+type Pool struct {
+	Strategy Strategy
+	// Isolate gives each host distinct SOCKS credentials, so that Tor daemons
+	// configured with IsolateSOCKSAuth use a different circuit for each host
+	Isolate bool
+	// MaxFailures is the number of consecutive failures after which a new
+	// circuit is requested, or the endpoint is marked unhealthy when it has no
+	// control port
+	MaxFailures int
+	// Timeout is used for health checks and control port commands
+	Timeout time.Duration
+
+	endpoints []*Endpoint
+	next      int
+	mu        sync.Mutex
+}
+
+// New returns a pool of the given proxies. Each proxy is an URL like
+// `socks5://tor:9050?control=tor:9051&password=secret`, where the optional
+// control and password params point to the Tor control port.
+func New(strategy Strategy, proxies ...string) (*Pool, error) {
+	pool := &Pool{
+		Strategy:    strategy,
+		MaxFailures: 5,
+		Timeout:     10 * time.Second,
+	}
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("proxypool: invalid proxy %q", proxy)
+		}
+		query := u.Query()
+		endpoint := &Endpoint{
+			ControlAddr:     query.Get("control"),
+			ControlPassword: query.Get("password"),
+			healthy:         true,
+		}
+		u.RawQuery = ""
+		endpoint.URL = u
+		pool.endpoints = append(pool.endpoints, endpoint)
+	}
+	if len(pool.endpoints) == 0 {
+		return nil, errors.New("proxypool: no proxy given")
+	}
+	return pool, nil
+}
+
+// Endpoints returns the proxies of the pool
+func (p *Pool) Endpoints() []*Endpoint {
+	return p.endpoints
+}
+
+// Proxy returns the proxy to use for a request to host
+func (p *Pool) Proxy(host string) (*url.URL, error) {
+	p.mu.Lock()
+	var healthy []*Endpoint
+	for _, endpoint := range p.endpoints {
+		if endpoint.healthy {
+			healthy = append(healthy, endpoint)
+		}
+	}
+	if len(healthy) == 0 {
+		p.mu.Unlock()
+		return nil, ErrNoHealthyProxy
+	}
+
+	var endpoint *Endpoint
+	switch p.Strategy {
+	case PinHost:
+		h := fnv.New32a()
+		h.Write([]byte(host))
+		endpoint = healthy[h.Sum32()%uint32(len(healthy))]
+	default:
+		endpoint = healthy[p.next%len(healthy)]
+		p.next++
+	}
+	p.mu.Unlock()
+
+	u := *endpoint.URL
+	if p.Isolate && endpoint.isSocks() && host != "" {
+		u.User = url.UserPassword(host, "isolate")
+	}
+	return &u, nil
+}
+
+// ProxyFunc returns a function to be used as the Proxy of an http.Transport
+func (p *Pool) ProxyFunc() func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		return p.Proxy(req.URL.Hostname())
+	}
+}
+
+// find returns the endpoint of a proxy URL returned by Proxy
+func (p *Pool) find(proxy string) *Endpoint {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil
+	}
+	for _, endpoint := range p.endpoints {
+		if endpoint.URL.Host == u.Host && endpoint.URL.Scheme == u.Scheme {
+			return endpoint
+		}
+	}
+	return nil
+}
+
+// ReportSuccess resets the failures of a proxy
+func (p *Pool) ReportSuccess(proxy string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if endpoint := p.find(proxy); endpoint != nil {
+		endpoint.failures = 0
+	}
+}
+
+// ReportFailure counts a failure of a proxy. After MaxFailures consecutive
+// failures a new circuit is requested through the control port, or the proxy
+// is marked unhealthy until the next health check, unless it is the last
+// healthy one. Only the failures of the proxy itself should be reported.
+func (p *Pool) ReportFailure(proxy string) error {
+	p.mu.Lock()
+	endpoint := p.find(proxy)
+	if endpoint == nil {
+		p.mu.Unlock()
+		return nil
+	}
+	endpoint.failures++
+	if endpoint.failures < p.MaxFailures {
+		p.mu.Unlock()
+		return nil
+	}
+	endpoint.failures = 0
+	if endpoint.ControlAddr == "" {
+		if endpoint.healthy && p.healthy() > 1 {
+			endpoint.healthy = false
+		}
+		p.mu.Unlock()
+		return nil
+	}
+	addr, password := endpoint.ControlAddr, endpoint.ControlPassword
+	p.mu.Unlock()
+	return NewNym(addr, password, p.Timeout)
+}
+
+// healthy returns the number of healthy endpoints. p.mu must be held.
+func (p *Pool) healthy() int {
+	healthy := 0
+	for _, endpoint := range p.endpoints {
+		if endpoint.healthy {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// Check health-checks every proxy of the pool and returns the number of
+// healthy ones
+func (p *Pool) Check() int {
+	var wg sync.WaitGroup
+	results := make([]bool, len(p.endpoints))
+	for i, endpoint := range p.endpoints {
+		wg.Add(1)
+		go func(i int, endpoint *Endpoint) {
+			defer wg.Done()
+			results[i] = check(endpoint, p.Timeout) == nil
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	healthy := 0
+	for i, endpoint := range p.endpoints {
+		endpoint.healthy = results[i]
+		if results[i] {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// check connects to a proxy and, for SOCKS5 ones, performs the method
+// negotiation
+func check(endpoint *Endpoint, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", endpoint.URL.Host, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !endpoint.isSocks() {
+		return nil
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte{5, 2, 0, 2}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := conn.Read(reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] == 0xff {
+		return fmt.Errorf("proxypool: unexpected SOCKS reply %v", reply)
+	}
+	return nil
+}
+
+// NewNym asks the Tor daemon listening on the control port addr to switch to
+// clean circuits
+func NewNym(addr, password string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	reader := bufio.NewReader(conn)
+	command := func(cmd string) error {
+		if _, err := fmt.Fprintf(conn, "%s\r\n", cmd); err != nil {
+			return err
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "250") {
+			return fmt.Errorf("proxypool: tor replied %q to %s", strings.TrimSpace(line), strings.Fields(cmd)[0])
+		}
+		return nil
+	}
+
+	if err := command(fmt.Sprintf("AUTHENTICATE %q", password)); err != nil {
+		return err
+	}
+	return command("SIGNAL NEWNYM")
+}
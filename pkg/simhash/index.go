@@ -0,0 +1,147 @@
+package simhash
+
+import (
+	"sort"
+	"sync"
+)
+
+// Match is an indexed hash close to the searched one
+type Match struct {
+	ID       uint
+	Hash     uint64
+	Distance int
+}
+
+// block is a range of bits of the hashes
+type block struct {
+	shift uint
+	mask  uint64
+}
+
+func (b block) key(hash uint64) uint64 {
+	return hash >> b.shift & b.mask
+}
+
+// Index finds the hashes within a maximum Hamming distance of a hash. The
+// hashes are split in distance+1 blocks: two hashes differing by at most
+// distance bits have at least one identical block, so only the hashes sharing
+// a block with the searched one are compared. It is safe for concurrent use.
+type Index struct {
+	mu       sync.RWMutex
+	distance int
+	blocks   []block
+	tables   []map[uint64][]uint
+	hashes   map[uint]uint64
+}
+
+// NewIndex returns an empty index of the hashes within distance bits, which
+// must be lower than 64
+func NewIndex(distance int) *Index {
+	if distance < 0 {
+		distance = 0
+	}
+	if distance > 63 {
+		distance = 63
+	}
+	count := distance + 1
+	idx := &Index{
+		distance: distance,
+		hashes:   make(map[uint]uint64),
+	}
+	shift := uint(0)
+	for i := 0; i < count; i++ {
+		width := uint(64 / count)
+		if i < 64%count {
+			width++
+		}
+		idx.blocks = append(idx.blocks, block{shift: shift, mask: 1<<width - 1})
+		idx.tables = append(idx.tables, make(map[uint64][]uint))
+		shift += width
+	}
+	return idx
+}
+
+// Distance returns the maximum distance of the index
+func (idx *Index) Distance() int {
+	return idx.distance
+}
+
+// Len returns the number of indexed hashes
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.hashes)
+}
+
+// Add indexes the hash of id, replacing its previous one
+func (idx *Index) Add(id uint, hash uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if old, ok := idx.hashes[id]; ok {
+		if old == hash {
+			return
+		}
+		idx.remove(id, old)
+	}
+	idx.hashes[id] = hash
+	for i, b := range idx.blocks {
+		key := b.key(hash)
+		idx.tables[i][key] = append(idx.tables[i][key], id)
+	}
+}
+
+// Remove removes the hash of id from the index
+func (idx *Index) Remove(id uint) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if hash, ok := idx.hashes[id]; ok {
+		idx.remove(id, hash)
+	}
+}
+
+func (idx *Index) remove(id uint, hash uint64) {
+	delete(idx.hashes, id)
+	for i, b := range idx.blocks {
+		key := b.key(hash)
+		ids := idx.tables[i][key]
+		for j, other := range ids {
+			if other == id {
+				ids = append(ids[:j], ids[j+1:]...)
+				break
+			}
+		}
+		if len(ids) == 0 {
+			delete(idx.tables[i], key)
+		} else {
+			idx.tables[i][key] = ids
+		}
+	}
+}
+
+// Search returns the indexed hashes within the distance of hash, closest
+// first
+func (idx *Index) Search(hash uint64) []Match {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var matches []Match
+	seen := make(map[uint]bool)
+	for i, b := range idx.blocks {
+		for _, id := range idx.tables[i][b.key(hash)] {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			other := idx.hashes[id]
+			if d := Distance(hash, other); d <= idx.distance {
+				matches = append(matches, Match{ID: id, Hash: other, Distance: d})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches
+}
@@ -0,0 +1,64 @@
+// Package simhash computes SimHash fingerprints of texts, so that near
+// duplicate texts get fingerprints differing by a few bits, and indexes them
+// for fast Hamming distance lookups.
+package simhash
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// shingleSize is the number of consecutive words of a feature
+const shingleSize = 3
+
+// words returns the lowercased words of text
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// features returns the shingles of consecutive words of text, or its words if
+// it is too short
+func features(text string) []string {
+	tokens := words(text)
+	if len(tokens) < shingleSize {
+		return tokens
+	}
+	shingles := make([]string, 0, len(tokens)-shingleSize+1)
+	for i := 0; i+shingleSize <= len(tokens); i++ {
+		shingles = append(shingles, strings.Join(tokens[i:i+shingleSize], " "))
+	}
+	return shingles
+}
+
+// Hash returns the SimHash of text. Empty texts hash to 0.
+func Hash(text string) uint64 {
+	var weights [64]int
+	for _, feature := range features(text) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		for i := 0; i < 64; i++ {
+			if sum&(1<<uint(i)) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+	var hash uint64
+	for i, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// Distance returns the Hamming distance between two hashes
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,100 @@
+package simhash
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const article = `Welcome to the market. We sell quality products shipped worldwide
+with stealth packaging. All orders are protected by escrow and our support team
+answers within a day. Read the rules before placing an order and always encrypt
+your address with our PGP key. Vendors must pay a bond to open a shop and every
+listing is reviewed by the staff before being published.`
+
+func TestHash(t *testing.T) {
+	if Hash("") != 0 {
+		t.Errorf("empty text should hash to 0")
+	}
+	if Hash(article) != Hash(strings.ToUpper(article)) {
+		t.Errorf("hash should not depend on case")
+	}
+
+	mirror := article + " Mirror operated by the staff."
+	captcha := strings.Replace(article, "within a day", "within a day token 8f3a9c", 1)
+	other := `Forum rules: be polite, no spam, no doxxing. Threads in the wrong
+section are moved by the moderators and repeated offenders get banned. Use the
+search before asking a question that has been answered many times already.`
+
+	tests := []struct {
+		name string
+		text string
+		max  int
+	}{
+		{"mirror", mirror, 10},
+		{"captcha", captcha, 10},
+	}
+	for _, test := range tests {
+		if d := Distance(Hash(article), Hash(test.text)); d > test.max {
+			t.Errorf("%s: expected distance <= %d, got %d", test.name, test.max, d)
+		}
+	}
+	if d := Distance(Hash(article), Hash(other)); d < 16 {
+		t.Errorf("unrelated texts should be far apart, got %d", d)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	idx := NewIndex(3)
+	hashes := make(map[uint]uint64)
+	for id := uint(1); id <= 2000; id++ {
+		hash := r.Uint64()
+		if id%10 == 0 {
+			// near duplicate of the previous hash
+			hash = hashes[id-1] ^ 1<<uint(r.Intn(64)) ^ 1<<uint(r.Intn(64))
+		}
+		hashes[id] = hash
+		idx.Add(id, hash)
+	}
+	if idx.Len() != len(hashes) {
+		t.Fatalf("expected %d hashes, got %d", len(hashes), idx.Len())
+	}
+
+	for i := 0; i < 200; i++ {
+		query := hashes[uint(r.Intn(len(hashes))+1)] ^ 1<<uint(r.Intn(64))
+		var expected []uint
+		for id := uint(1); id <= uint(len(hashes)); id++ {
+			if Distance(query, hashes[id]) <= 3 {
+				expected = append(expected, id)
+			}
+		}
+		var got []uint
+		for _, match := range idx.Search(query) {
+			got = append(got, match.ID)
+		}
+		if len(got) != len(expected) {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestIndexUpdate(t *testing.T) {
+	idx := NewIndex(2)
+	idx.Add(1, 0xff)
+	idx.Add(2, 0xfe)
+	idx.Add(1, 0xff00)
+
+	got := idx.Search(0xff)
+	if !reflect.DeepEqual(got, []Match{{ID: 2, Hash: 0xfe, Distance: 1}}) {
+		t.Errorf("the old hash of 1 should be replaced, got %v", got)
+	}
+	idx.Remove(2)
+	if got := idx.Search(0xff); len(got) != 0 {
+		t.Errorf("2 should be removed, got %v", got)
+	}
+	if got := idx.Search(0xff01); len(got) != 1 || got[0].ID != 1 {
+		t.Errorf("expected 1, got %v", got)
+	}
+}
@@ -0,0 +1,100 @@
+// Package sitemap parses sitemap.xml files and sitemap indexes, plain or
+// gzipped, as described on https://www.sitemaps.org/protocol.html
+package sitemap
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+)
+
+// URL is an entry of a sitemap
+type URL struct {
+	Loc        string
+	LastMod    time.Time
+	ChangeFreq string
+	Priority   float64
+}
+
+// Sitemap is the content of a sitemap file: either a list of pages or, for a
+// sitemap index, a list of other sitemaps
+type Sitemap struct {
+	URLs     []URL
+	Sitemaps []string
+}
+
+type xmlEntry struct {
+	Loc        string  `xml:"loc"`
+	LastMod    string  `xml:"lastmod"`
+	ChangeFreq string  `xml:"changefreq"`
+	Priority   float64 `xml:"priority"`
+}
+
+type xmlSitemap struct {
+	XMLName  xml.Name
+	URLs     []xmlEntry `xml:"url"`
+	Sitemaps []xmlEntry `xml:"sitemap"`
+}
+
+// lastmod is in the W3C datetime format, which allows a date without time
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+}
+
+func parseDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Parse reads a sitemap or a sitemap index from r, transparently
+// decompressing it if it is gzipped
+func Parse(r io.Reader) (*Sitemap, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	var doc xmlSitemap
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	sitemap := &Sitemap{}
+	for _, entry := range doc.URLs {
+		loc := strings.TrimSpace(entry.Loc)
+		if loc == "" {
+			continue
+		}
+		sitemap.URLs = append(sitemap.URLs, URL{
+			Loc:        loc,
+			LastMod:    parseDate(entry.LastMod),
+			ChangeFreq: strings.TrimSpace(entry.ChangeFreq),
+			Priority:   entry.Priority,
+		})
+	}
+	for _, entry := range doc.Sitemaps {
+		if loc := strings.TrimSpace(entry.Loc); loc != "" {
+			sitemap.Sitemaps = append(sitemap.Sitemaps, loc)
+		}
+	}
+	return sitemap, nil
+}
@@ -0,0 +1,91 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+	"time"
+)
+
+const urlset = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>http://example.com/</loc>
+    <lastmod>2020-05-01</lastmod>
+    <changefreq>daily</changefreq>
+    <priority>0.8</priority>
+  </url>
+  <url>
+    <loc> http://example.com/about </loc>
+    <lastmod>2020-04-01T10:30:00+02:00</lastmod>
+  </url>
+  <url>
+    <loc></loc>
+  </url>
+</urlset>`
+
+const index = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap>
+    <loc>http://example.com/sitemap1.xml.gz</loc>
+    <lastmod>2020-05-01T18:23:17+00:00</lastmod>
+  </sitemap>
+  <sitemap>
+    <loc>http://example.com/sitemap2.xml</loc>
+  </sitemap>
+</sitemapindex>`
+
+func TestParseURLSet(t *testing.T) {
+	s, err := Parse(strings.NewReader(urlset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.URLs) != 2 || len(s.Sitemaps) != 0 {
+		t.Fatalf("should have 2 urls and no sitemaps, got %d and %d", len(s.URLs), len(s.Sitemaps))
+	}
+	first := s.URLs[0]
+	if first.Loc != "http://example.com/" || first.ChangeFreq != "daily" || first.Priority != 0.8 {
+		t.Errorf("unexpected url %+v", first)
+	}
+	if !first.LastMod.Equal(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected lastmod %v", first.LastMod)
+	}
+	if s.URLs[1].Loc != "http://example.com/about" {
+		t.Errorf("loc should be trimmed, got %q", s.URLs[1].Loc)
+	}
+	if !s.URLs[1].LastMod.Equal(time.Date(2020, 4, 1, 8, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected lastmod %v", s.URLs[1].LastMod)
+	}
+}
+
+func TestParseIndex(t *testing.T) {
+	s, err := Parse(strings.NewReader(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(s.Sitemaps, ",") != "http://example.com/sitemap1.xml.gz,http://example.com/sitemap2.xml" {
+		t.Errorf("unexpected sitemaps %v", s.Sitemaps)
+	}
+}
+
+func TestParseGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(urlset))
+	gz.Close()
+
+	s, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.URLs) != 2 {
+		t.Errorf("should have 2 urls, got %d", len(s.URLs))
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse(strings.NewReader("not a sitemap")); err == nil {
+		t.Error("should fail on invalid xml")
+	}
+}
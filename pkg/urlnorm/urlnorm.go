@@ -0,0 +1,119 @@
+// Package urlnorm canonicalizes URLs so that equivalent addresses of the same
+// page map to a single string.
+package urlnorm
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DefaultDropParams are the tracking and session query params removed by
+// default. A trailing `*` matches any param with that prefix.
+var DefaultDropParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"phpsessid",
+	"jsessionid",
+	"aspsessionid",
+	"sessionid",
+	"session_id",
+	"sid",
+}
+
+var (
+	// ErrNotAbsolute is returned for URLs without a scheme or a host
+	ErrNotAbsolute = errors.New("urlnorm: url is not absolute")
+
+	pathSessionRegexp = regexp.MustCompile(`(?i);(jsessionid|phpsessid|sid)=[^/?]*`)
+
+	defaultPorts = map[string]string{
+		"http":  "80",
+		"https": "443",
+	}
+)
+
+// Canonicalizer turns URLs into their canonical form
+type Canonicalizer struct {
+	exact    map[string]bool
+	prefixes []string
+}
+
+// New returns a Canonicalizer which drops DefaultDropParams and the given
+// extra query params
+func New(dropParams ...string) *Canonicalizer {
+	c := &Canonicalizer{exact: make(map[string]bool)}
+	for _, param := range append(DefaultDropParams, dropParams...) {
+		param = strings.ToLower(strings.TrimSpace(param))
+		if param == "" {
+			continue
+		}
+		if strings.HasSuffix(param, "*") {
+			c.prefixes = append(c.prefixes, strings.TrimSuffix(param, "*"))
+		} else {
+			c.exact[param] = true
+		}
+	}
+	return c
+}
+
+// Canonicalize lowercases scheme and host, removes default ports, fragments,
+// dropped params, dot segments and trailing slashes, and sorts the query
+func (c *Canonicalizer) Canonicalize(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", ErrNotAbsolute
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.User = nil
+	u.Fragment = ""
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host = host + ":" + port
+	}
+	u.Host = host
+
+	escaped := pathSessionRegexp.ReplaceAllString(u.EscapedPath(), "")
+	if escaped == "" {
+		escaped = "/"
+	}
+	escaped = path.Clean(escaped)
+	unescaped, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", err
+	}
+	u.Path = unescaped
+	u.RawPath = escaped
+
+	query := u.Query()
+	for name := range query {
+		if c.drop(name) {
+			query.Del(name)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+func (c *Canonicalizer) drop(param string) bool {
+	param = strings.ToLower(param)
+	if c.exact[param] {
+		return true
+	}
+	for _, prefix := range c.prefixes {
+		if strings.HasPrefix(param, prefix) {
+			return true
+		}
+	}
+	return false
+}
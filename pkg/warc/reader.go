@@ -0,0 +1,91 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Reader reads the records of a WARC file, gzipped or not
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a reader of the records of r
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		// the gzip members of the records are read as a single stream
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(gz)
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the file
+func (r *Reader) Next() (*Record, error) {
+	var version string
+	for version == "" {
+		line, err := r.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && strings.TrimSpace(line) == "" {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		version = strings.TrimSpace(line)
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, fmt.Errorf("invalid record version %q", version)
+	}
+
+	header := make(Header)
+	for {
+		line, err := r.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid header field %q", line)
+		}
+		header[line[:colon]] = strings.TrimSpace(line[colon+1:])
+	}
+
+	length, err := strconv.ParseInt(header.Get(ContentLength), 10, 64)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get(ContentLength))
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r.r, content); err != nil {
+		return nil, err
+	}
+	return &Record{Header: header, Content: content}, nil
+}
+
+// ReadResponse parses the HTTP response of a response record. The body of
+// the returned response is fully buffered.
+func (r *Record) ReadResponse() (*http.Response, error) {
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(r.Content)), nil)
+}
+
+// ReadRequest parses the HTTP request of a request record
+func (r *Record) ReadRequest() (*http.Request, error) {
+	return http.ReadRequest(bufio.NewReader(bytes.NewReader(r.Content)))
+}
@@ -0,0 +1,142 @@
+// Package warc reads and writes WARC 1.1 files, the ISO 28500 format used to
+// archive web crawls.
+package warc
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Version is the version line of the written records
+const Version = "WARC/1.1"
+
+// Record types
+const (
+	Warcinfo = "warcinfo"
+	Request  = "request"
+	Response = "response"
+	Metadata = "metadata"
+)
+
+// Header fields
+const (
+	Type          = "WARC-Type"
+	RecordID      = "WARC-Record-ID"
+	Date          = "WARC-Date"
+	TargetURI     = "WARC-Target-URI"
+	ConcurrentTo  = "WARC-Concurrent-To"
+	RefersTo      = "WARC-Refers-To"
+	BlockDigest   = "WARC-Block-Digest"
+	PayloadDigest = "WARC-Payload-Digest"
+	Filename      = "WARC-Filename"
+	ContentType   = "Content-Type"
+	ContentLength = "Content-Length"
+)
+
+// fieldOrder is the order of the first fields of a record, the others being
+// sorted by name
+var fieldOrder = []string{Type, RecordID, Date, TargetURI, ConcurrentTo, RefersTo, Filename}
+
+// Header is the header of a record. Field names are case insensitive.
+type Header map[string]string
+
+// Get returns the value of a field
+func (h Header) Get(name string) string {
+	if value, ok := h[name]; ok {
+		return value
+	}
+	for key, value := range h {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// Set sets the value of a field, replacing any field with the same name
+func (h Header) Set(name, value string) {
+	for key := range h {
+		if strings.EqualFold(key, name) {
+			delete(h, key)
+		}
+	}
+	h[name] = value
+}
+
+// Record is a WARC record
+type Record struct {
+	Header  Header
+	Content []byte
+}
+
+// NewRecord returns a record of the given type with a new id, dated now
+func NewRecord(recordType string, content []byte) *Record {
+	return &Record{
+		Header: Header{
+			Type:     recordType,
+			RecordID: NewID(),
+			Date:     time.Now().UTC().Format(time.RFC3339),
+		},
+		Content: content,
+	}
+}
+
+// Type returns the type of the record
+func (r *Record) Type() string {
+	return r.Header.Get(Type)
+}
+
+// ID returns the id of the record
+func (r *Record) ID() string {
+	return r.Header.Get(RecordID)
+}
+
+// Date returns the date of the record, or the zero time if it is invalid
+func (r *Record) Date() time.Time {
+	date, _ := time.Parse(time.RFC3339Nano, r.Header.Get(Date))
+	return date
+}
+
+// fields returns the header fields of the record, in the order they are
+// written
+func (r *Record) fields() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range fieldOrder {
+		if _, ok := r.Header[name]; ok {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	var others []string
+	for name := range r.Header {
+		if !seen[name] && !strings.EqualFold(name, ContentLength) {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	return append(names, others...)
+}
+
+// NewID returns a new record id, a random UUID URN
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Digest returns the SHA-1 digest of data, in the base32 form used by the
+// digest fields
+func Digest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,164 @@
+package warc
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testRequest  = "GET / HTTP/1.1\r\nHost: example.onion\r\n\r\n"
+	testBody     = "<html><title>hello</title></html>"
+	testResponse = "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 33\r\n\r\n" + testBody
+)
+
+func readAll(t *testing.T, path string) []*Record {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []*Record
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestWriteExchange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewWriter(dir, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Info = map[string]string{"software": "tor-spider"}
+	date := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	err = w.WriteExchange("http://example.onion/", date, []byte(testRequest), []byte(testResponse), []byte(testBody), map[string]string{"via": "socks5://tor:9050"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "test-*.warc.gz"))
+	if len(files) != 1 {
+		t.Fatalf("should write 1 file, got %d", len(files))
+	}
+	records := readAll(t, files[0])
+	var types []string
+	for _, r := range records {
+		types = append(types, r.Type())
+	}
+	if strings.Join(types, ",") != "warcinfo,request,response,metadata" {
+		t.Fatalf("unexpected records %v", types)
+	}
+
+	info, req, resp, meta := records[0], records[1], records[2], records[3]
+	if info.Header.Get(Filename) != filepath.Base(files[0]) {
+		t.Errorf("warcinfo should name the file, got %q", info.Header.Get(Filename))
+	}
+	if !strings.Contains(string(info.Content), "software: tor-spider") {
+		t.Errorf("warcinfo should hold the info, got %q", info.Content)
+	}
+	if req.Header.Get(ConcurrentTo) != resp.ID() || meta.Header.Get(RefersTo) != resp.ID() {
+		t.Error("request and metadata should refer to the response")
+	}
+	if !resp.Date().Equal(date) {
+		t.Errorf("unexpected date %v", resp.Date())
+	}
+	if resp.Header.Get(PayloadDigest) != Digest([]byte(testBody)) {
+		t.Errorf("unexpected payload digest %s", resp.Header.Get(PayloadDigest))
+	}
+	if resp.Header.Get(BlockDigest) != Digest([]byte(testResponse)) {
+		t.Errorf("unexpected block digest %s", resp.Header.Get(BlockDigest))
+	}
+
+	res, err := resp.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != 200 || string(body) != testBody {
+		t.Errorf("unexpected response %d %q", res.StatusCode, body)
+	}
+	httpReq, err := req.ReadRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if httpReq.Host != "example.onion" {
+		t.Errorf("unexpected request host %q", httpReq.Host)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewWriter(dir, "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		err := w.WriteExchange("http://example.onion/", time.Now(), []byte(testRequest), []byte(testResponse), []byte(testBody), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	if err := w.WriteRecord(NewRecord(Metadata, nil)); err != ErrClosed {
+		t.Errorf("should not write once closed, got %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "test-*.warc.gz"))
+	if len(files) != 3 {
+		t.Fatalf("should rotate after every exchange, got %d files", len(files))
+	}
+	for _, file := range files {
+		if records := readAll(t, file); len(records) != 3 {
+			t.Errorf("%s should hold 3 records, got %d", file, len(records))
+		}
+	}
+}
+
+func TestUncompressed(t *testing.T) {
+	record := "WARC/1.0\r\nWARC-Type: response\r\ncontent-length: 5\r\n\r\nhello\r\n\r\n"
+	r, err := NewReader(strings.NewReader(record + record))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		rec, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Type() != Response || string(rec.Content) != "hello" {
+			t.Errorf("unexpected record %v %q", rec.Header, rec.Content)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("should end with io.EOF, got %v", err)
+	}
+}
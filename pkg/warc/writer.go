@@ -0,0 +1,179 @@
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Writer writes records to gzipped WARC files of a directory, compressing
+// each record separately so that they can be read independently. A new file
+// is started when the current one reaches MaxSize bytes.
+type Writer struct {
+	Dir     string
+	Prefix  string
+	MaxSize int64
+	// Info is the content of the warcinfo record starting every file
+	Info map[string]string
+
+	file   *os.File
+	size   int64
+	serial int
+	closed bool
+	mu     sync.Mutex
+}
+
+// ErrClosed is returned when writing to a closed writer
+var ErrClosed = errors.New("warc: writer is closed")
+
+// NewWriter returns a writer creating its files in dir
+func NewWriter(dir, prefix string, maxSize int64) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Writer{Dir: dir, Prefix: prefix, MaxSize: maxSize}, nil
+}
+
+// WriteRecord writes a record, setting its Content-Length and its block
+// digest
+func (w *Writer) WriteRecord(r *Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if w.file == nil || (w.MaxSize > 0 && w.size >= w.MaxSize) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	return w.write(r)
+}
+
+// WriteExchange writes the request and the response of a URL, and a metadata
+// record referring to the response when metadata is not empty. request and
+// response are HTTP messages, and payload is the body of the response.
+func (w *Writer) WriteExchange(target string, date time.Time, request, response, payload []byte, metadata map[string]string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if w.file == nil || (w.MaxSize > 0 && w.size >= w.MaxSize) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	warcDate := date.UTC().Format(time.RFC3339)
+
+	resp := NewRecord(Response, response)
+	resp.Header.Set(Date, warcDate)
+	resp.Header.Set(TargetURI, target)
+	resp.Header.Set(ContentType, "application/http; msgtype=response")
+	resp.Header.Set(PayloadDigest, Digest(payload))
+
+	req := NewRecord(Request, request)
+	req.Header.Set(Date, warcDate)
+	req.Header.Set(TargetURI, target)
+	req.Header.Set(ContentType, "application/http; msgtype=request")
+	req.Header.Set(ConcurrentTo, resp.ID())
+
+	records := []*Record{req, resp}
+	if len(metadata) > 0 {
+		var names []string
+		for name := range metadata {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var content bytes.Buffer
+		for _, name := range names {
+			fmt.Fprintf(&content, "%s: %s\r\n", name, metadata[name])
+		}
+		meta := NewRecord(Metadata, content.Bytes())
+		meta.Header.Set(Date, warcDate)
+		meta.Header.Set(TargetURI, target)
+		meta.Header.Set(ContentType, "application/warc-fields")
+		meta.Header.Set(RefersTo, resp.ID())
+		meta.Header.Set(ConcurrentTo, resp.ID())
+		records = append(records, meta)
+	}
+	for _, r := range records {
+		if err := w.write(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the current file. Later writes fail with ErrClosed.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// rotate closes the current file and starts a new one with a warcinfo record
+func (w *Writer) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+	w.serial++
+	name := fmt.Sprintf("%s-%s-%05d.warc.gz", w.Prefix, time.Now().UTC().Format("20060102150405"), w.serial)
+	file, err := os.OpenFile(filepath.Join(w.Dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w.file, w.size = file, 0
+
+	var names []string
+	for name := range w.Info {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var content bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&content, "%s: %s\r\n", name, w.Info[name])
+	}
+	info := NewRecord(Warcinfo, content.Bytes())
+	info.Header.Set(Filename, name)
+	info.Header.Set(ContentType, "application/warc-fields")
+	return w.write(info)
+}
+
+// write writes a record as a gzip member of the current file
+func (w *Writer) write(r *Record) error {
+	if block := r.Header.Get(BlockDigest); block == "" {
+		r.Header.Set(BlockDigest, Digest(r.Content))
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	fmt.Fprintf(gz, "%s\r\n", Version)
+	for _, name := range r.fields() {
+		fmt.Fprintf(gz, "%s: %s\r\n", name, r.Header[name])
+	}
+	fmt.Fprintf(gz, "%s: %s\r\n\r\n", ContentLength, strconv.Itoa(len(r.Content)))
+	gz.Write(r.Content)
+	gz.Write([]byte("\r\n\r\n"))
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	n, err := w.file.Write(buf.Bytes())
+	w.size += int64(n)
+	return err
+}
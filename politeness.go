@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/samirettali/tor-spider/pkg/hostlimit"
+	"github.com/samirettali/tor-spider/pkg/proxypool"
+)
+
+// politeTransport is an http.RoundTripper which waits for the spider-wide host
+// limiter before each request, so that every collector shares the same per
+// host concurrency and delay. Its clients must not have a timeout: Timeout
+// starts once the limiter lets the request through, so that the time spent
+// waiting for the host is never reported as a failed fetch.
+//
+// The proxy of each request is picked from the pool, which is told whether the
+// proxy could be used. Its URL is returned in the proxyHeader of the response.
+// The wrapped transport must use contextProxy as its Proxy.
+type politeTransport struct {
+	http.RoundTripper
+	limiter *hostlimit.Limiter
+	proxies *proxypool.Pool
+	Timeout time.Duration
+}
+
+// defaultFetchTimeout is the default timeout of a request, the same as colly's
+const defaultFetchTimeout = 10 * time.Second
+
+// proxyHeader is the response header holding the URL of the proxy used
+const proxyHeader = "X-Spider-Proxy"
+
+type proxyKey struct{}
+
+// contextProxy returns the proxy picked by the politeTransport for a request
+func contextProxy(req *http.Request) (*url.URL, error) {
+	proxyURL, _ := req.Context().Value(proxyKey{}).(*url.URL)
+	return proxyURL, nil
+}
+
+func (t *politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	var proxyURL *url.URL
+	if t.proxies != nil {
+		var err error
+		if proxyURL, err = t.proxies.Proxy(host); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.limiter.Acquire(req.Context(), host); err != nil {
+		return nil, err
+	}
+
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	ctx = context.WithValue(ctx, proxyKey{}, proxyURL)
+	resp, err := t.RoundTripper.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		netErr, ok := err.(net.Error)
+		t.limiter.Release(host, ok && netErr.Timeout())
+		t.reportProxy(proxyURL, nil, err)
+		return nil, err
+	}
+	t.reportProxy(proxyURL, resp, nil)
+	if proxyURL != nil {
+		resp.Header.Set(proxyHeader, proxyURL.String())
+	}
+
+	// the request ends once its body has been read
+	slowDown := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+	retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() {
+		cancel()
+		t.limiter.Release(host, slowDown)
+		if slowDown && retryAfter > 0 {
+			t.limiter.Defer(host, retryAfter)
+		}
+	}}
+	return resp, nil
+}
+
+// reportProxy tells the pool whether the proxy of a request could be used.
+// The errors of the onions are not the fault of the proxy, and the error pages
+// of an HTTP proxy are left to the callers, which read their body.
+func (t *politeTransport) reportProxy(proxyURL *url.URL, resp *http.Response, err error) {
+	if proxyURL == nil {
+		return
+	}
+	if err != nil {
+		if classifyFailure(0, nil, err) != FailureSocks {
+			return
+		}
+		if err := t.proxies.ReportFailure(proxyURL.String()); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+	if proxyURL.Scheme == "http" && resp.StatusCode >= http.StatusBadGateway && resp.StatusCode <= http.StatusGatewayTimeout {
+		return
+	}
+	t.proxies.ReportSuccess(proxyURL.String())
+}
+
+// releaseBody calls release when the body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// parseRetryAfter returns the delay of a Retry-After header, given either in
+// seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// newHTTPClient returns a client for the requests made outside of the
+// collectors, going through the proxies and the host limiter
+func (spider *Spider) newHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &politeTransport{limiter: spider.hosts, proxies: spider.proxies, Timeout: 60 * time.Second, RoundTripper: &http.Transport{
+			Proxy: contextProxy,
+			DialContext: (&net.Dialer{
+				Timeout: 60 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout: 60 * time.Second,
+			DisableKeepAlives:   true,
+		}},
+	}
+}
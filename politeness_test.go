@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/samirettali/tor-spider/pkg/hostlimit"
+	"github.com/samirettali/tor-spider/pkg/proxypool"
+)
+
+// newProxy returns an HTTP proxy answering every request itself
+func newProxy() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+}
+
+func newTestSpider(t *testing.T, proxies ...string) *Spider {
+	pool, err := proxypool.New(proxypool.RoundRobin, proxies...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.MaxFailures = 2
+	return &Spider{
+		hosts:   hostlimit.New(hostlimit.Rule{Concurrency: 1}),
+		proxies: pool,
+	}
+}
+
+func TestPoliteTransportReportsSuccess(t *testing.T) {
+	first, second := newProxy(), newProxy()
+	defer first.Close()
+	defer second.Close()
+	spider := newTestSpider(t, first.URL, second.URL)
+
+	spider.proxies.ReportFailure(first.URL)
+	resp, err := spider.newHTTPClient().Get("http://example.onion/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Fatalf("request should go through the proxy, got %q", body)
+	}
+	if proxy := resp.Header.Get(proxyHeader); proxy != first.URL {
+		t.Errorf("response should name the proxy %s, got %q", first.URL, proxy)
+	}
+
+	// the success reset the failures of the proxy
+	spider.proxies.ReportFailure(first.URL)
+	if !spider.proxies.Endpoints()[0].Healthy() {
+		t.Error("proxy should be healthy after a success")
+	}
+}
+
+func TestPoliteTransportReportsFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := "http://" + l.Addr().String()
+	l.Close()
+	alive := newProxy()
+	defer alive.Close()
+	spider := newTestSpider(t, dead, alive.URL)
+
+	client := spider.newHTTPClient()
+	for i := 0; i < 2; i++ {
+		if _, err := client.Get("http://example.onion/"); err == nil {
+			t.Fatal("request through the dead proxy should fail")
+		}
+		// the next request goes through the alive proxy
+		resp, err := client.Get("http://example.onion/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if spider.proxies.Endpoints()[0].Healthy() {
+		t.Error("dead proxy should be unhealthy")
+	}
+}
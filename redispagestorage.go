@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisStreamPageStorage is an implementation of the PageStorage interface
+// which publishes the pages to a redis stream, trimmed to about MaxLen
+// entries, for the consumers processing the crawled pages
+type RedisStreamPageStorage struct {
+	Address  string
+	Password string
+	DB       int
+	Stream   string
+	MaxLen   int64
+	client   *redis.Client
+}
+
+// Init initializes the connection to redis
+func (r *RedisStreamPageStorage) Init() error {
+	if r.client == nil {
+		r.client = redis.NewClient(&redis.Options{
+			Addr:     r.Address,
+			Password: r.Password,
+			DB:       r.DB,
+		})
+	}
+	if _, err := r.client.Ping().Result(); err != nil {
+		return fmt.Errorf("Redis connection error: %s", err.Error())
+	}
+	return nil
+}
+
+// SavePage adds a page to the stream
+func (r *RedisStreamPageStorage) SavePage(page PageInfo) error {
+	data, err := json.Marshal(newPageRecord(page))
+	if err != nil {
+		return err
+	}
+	return r.client.XAdd(&redis.XAddArgs{
+		Stream:       r.Stream,
+		MaxLenApprox: r.MaxLen,
+		Values: map[string]interface{}{
+			"url":  page.URL,
+			"page": data,
+		},
+	}).Err()
+}
+
+// Close closes the connection to redis
+func (r *RedisStreamPageStorage) Close() error {
+	return r.client.Close()
+}
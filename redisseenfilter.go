@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisSeenFilter is an implementation of the SeenFilter interface which keeps
+// a key per URL that expires after Window, so that a URL is enqueued only once
+// per revisit window
+type RedisSeenFilter struct {
+	Address  string
+	Password string
+	DB       int
+	Prefix   string
+	Window   time.Duration
+	client   *redis.Client
+}
+
+// Init initializes the connection to redis
+func (f *RedisSeenFilter) Init() error {
+	if f.client == nil {
+		f.client = redis.NewClient(&redis.Options{
+			Addr:     f.Address,
+			Password: f.Password,
+			DB:       f.DB,
+		})
+	}
+	if _, err := f.client.Ping().Result(); err != nil {
+		return fmt.Errorf("Redis connection error: %s", err.Error())
+	}
+	return nil
+}
+
+// Seen marks URL as seen and reports whether it was already seen inside the
+// current window
+func (f *RedisSeenFilter) Seen(URL string) (bool, error) {
+	added, err := f.client.SetNX(f.Prefix+":seen:"+strToMD5(URL), 1, f.Window).Result()
+	if err != nil {
+		return false, err
+	}
+	return !added, nil
+}
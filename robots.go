@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/temoto/robotstxt"
+)
+
+// robotsAgent is the user agent looked up in the robots.txt files
+const robotsAgent = "tor-spider"
+
+// robotsTTL is the time after which a cached robots.txt is fetched again
+const robotsTTL = 24 * time.Hour
+
+type robotsEntry struct {
+	data    *robotstxt.RobotsData
+	fetched time.Time
+	once    sync.Once
+}
+
+// robotsData returns the robots.txt of the host of u, fetching it once a day.
+// Hosts whose robots.txt can not be fetched allow everything.
+func (spider *Spider) robotsData(u *url.URL) *robotstxt.RobotsData {
+	root := u.Scheme + "://" + u.Host
+
+	spider.robotsMu.Lock()
+	if spider.robots == nil {
+		spider.robots = make(map[string]*robotsEntry)
+	}
+	entry, ok := spider.robots[root]
+	if !ok || time.Since(entry.fetched) > robotsTTL {
+		entry = &robotsEntry{fetched: time.Now()}
+		spider.robots[root] = entry
+	}
+	spider.robotsMu.Unlock()
+
+	entry.once.Do(func() {
+		entry.data = spider.fetchRobots(root)
+		if group := entry.data.FindGroup(robotsAgent); group != nil && group.CrawlDelay > 0 {
+			spider.Logger.Debugf("Using crawl delay %v for %s", group.CrawlDelay, u.Hostname())
+			spider.hosts.SetDelay(u.Hostname(), group.CrawlDelay)
+		}
+	})
+	return entry.data
+}
+
+func (spider *Spider) fetchRobots(root string) *robotstxt.RobotsData {
+	resp, err := spider.client.Get(root + "/robots.txt")
+	if err == nil {
+		defer resp.Body.Close()
+		data, err := robotstxt.FromResponse(resp)
+		if err == nil {
+			return data
+		}
+	}
+	spider.Logger.Debugf("Could not get robots.txt of %s: %v", root, err)
+	data, _ := robotstxt.FromStatusAndBytes(http.StatusNotFound, nil)
+	return data
+}
+
+// robotsAllowed tells whether the robots.txt of its host allows to crawl u
+func (spider *Spider) robotsAllowed(u *url.URL) bool {
+	return spider.robotsData(u).TestAgent(u.RequestURI(), robotsAgent)
+}
+
+// checkRobots aborts the requests disallowed by robots.txt
+func (spider *Spider) checkRobots(r *colly.Request) {
+	if !spider.robotsAllowed(r.URL) {
+		spider.Logger.Debugf("Skipping %s disallowed by robots.txt", r.URL)
+		r.Abort()
+	}
+}
@@ -4,11 +4,25 @@ import (
 	"fmt"
 )
 
-// SavePageError is an error used to signal that there are no jobs in the storage
+// DocumentError is the failure of a single document of a bulk request
+type DocumentError struct {
+	ID     string `json:"id,omitempty"`
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+	Type   string `json:"type,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// SavePageError is an error used to signal that some pages could not be saved
 type SavePageError struct {
-	Err string
+	Err      string
+	Failures []DocumentError
 }
 
 func (e *SavePageError) Error() string {
-	return fmt.Sprintf(e.Err)
+	if len(e.Failures) == 0 {
+		return fmt.Sprintf(e.Err)
+	}
+	first := e.Failures[0]
+	return fmt.Sprintf("%s (%s: %d %s %s)", e.Err, first.URL, first.Status, first.Type, first.Reason)
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// PageSchedule keeps track of the visits of a URL in order to decide when it
+// has to be crawled again. Pages whose content changes often are revisited
+// sooner, static or dead ones later.
+type PageSchedule struct {
+	gorm.Model
+	URLHash     string `gorm:"size:32;unique_index"`
+	URL         string `gorm:"type:text"`
+	Domain      string `gorm:"index:domain"`
+	LastVisit   time.Time
+	LastStatus  int
+	Fingerprint string
+	Visits      int
+	Changes     int
+	Interval    time.Duration
+	NextVisit   time.Time `gorm:"index:next_visit"`
+}
+
+// recordVisit updates the schedule of URL after a visit. An empty fingerprint
+// means that the content could not be fetched.
+func (spider *Spider) recordVisit(URL, domain string, status int, fingerprint string) {
+	now := time.Now()
+	var schedule PageSchedule
+	if spider.rdbms.Where("url_hash = ?", strToMD5(URL)).First(&schedule).RecordNotFound() {
+		schedule = PageSchedule{
+			URLHash:  strToMD5(URL),
+			URL:      URL,
+			Domain:   domain,
+			Interval: spider.minRevisit,
+		}
+	} else {
+		switch {
+		case fingerprint == "" || status >= 400:
+			// dead or broken pages are checked less and less often
+			schedule.Interval *= 4
+		case fingerprint != schedule.Fingerprint:
+			schedule.Interval /= 2
+			schedule.Changes++
+		default:
+			schedule.Interval *= 2
+		}
+	}
+
+	if schedule.Interval < spider.minRevisit {
+		schedule.Interval = spider.minRevisit
+	}
+	if schedule.Interval > spider.maxRevisit {
+		schedule.Interval = spider.maxRevisit
+	}
+	if fingerprint != "" {
+		schedule.Fingerprint = fingerprint
+	}
+	schedule.Visits++
+	schedule.LastVisit = now
+	schedule.LastStatus = status
+	schedule.NextVisit = now.Add(schedule.Interval)
+
+	if err := spider.rdbms.Save(&schedule).Error; err != nil {
+		spider.Logger.Error(err)
+	}
+}
+
+// startScheduler periodically enqueues the URLs that are due for a revisit
+func (spider *Spider) startScheduler() {
+	spider.feeders.Add(1)
+	go func() {
+		defer spider.feeders.Done()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-spider.quit:
+				return
+			case <-ticker.C:
+				spider.scheduleRevisits()
+			}
+		}
+	}()
+}
+
+func (spider *Spider) scheduleRevisits() {
+	var schedules []PageSchedule
+	err := spider.rdbms.
+		Where("next_visit <= ?", time.Now()).
+		Order("next_visit").
+		Limit(cap(spider.jobs) / 10).
+		Find(&schedules).Error
+	if err != nil {
+		spider.Logger.Error(err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		job, err := spider.newJob(schedule.URL, "", 0)
+		if err != nil {
+			spider.Logger.Error(err)
+			continue
+		}
+		// push the next visit forward so that the URL is not enqueued again
+		// while it waits to be crawled
+		schedule.NextVisit = time.Now().Add(schedule.Interval)
+		if err := spider.rdbms.Save(&schedule).Error; err != nil {
+			spider.Logger.Error(err)
+			continue
+		}
+		spider.enqueue(job)
+	}
+
+	if len(schedules) > 0 {
+		spider.Logger.Infof("Scheduled %d revisits", len(schedules))
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/samirettali/tor-spider/pkg/sitemap"
+)
+
+// maxSitemaps is the maximum number of sitemaps read for a host
+const maxSitemaps = 50
+
+// maxSitemapSize is the maximum size of an uncompressed sitemap allowed by the
+// protocol
+const maxSitemapSize = 50 * 1024 * 1024
+
+// lastmodPriority returns the priority boost of a page of a sitemap, recently
+// modified pages being crawled first
+func lastmodPriority(lastmod time.Time) int {
+	if lastmod.IsZero() {
+		return 0
+	}
+	switch age := time.Since(lastmod); {
+	case age < 24*time.Hour:
+		return 3
+	case age < 7*24*time.Hour:
+		return 2
+	case age < 30*24*time.Hour:
+		return 1
+	}
+	return 0
+}
+
+// discoverSitemaps seeds jobs from the sitemaps of the host of seed, listed in
+// its robots.txt or at /sitemap.xml. Each host is handled once a day.
+func (spider *Spider) discoverSitemaps(seed string) {
+	u, err := url.Parse(seed)
+	if err != nil || u.Host == "" {
+		return
+	}
+	root := u.Scheme + "://" + u.Host
+
+	spider.robotsMu.Lock()
+	if spider.sitemapHosts == nil {
+		spider.sitemapHosts = make(map[string]time.Time)
+	}
+	if last, ok := spider.sitemapHosts[root]; ok && time.Since(last) < robotsTTL {
+		spider.robotsMu.Unlock()
+		return
+	}
+	spider.sitemapHosts[root] = time.Now()
+	spider.robotsMu.Unlock()
+
+	queue := spider.robotsData(u).Sitemaps
+	if len(queue) == 0 {
+		queue = []string{root + "/sitemap.xml"}
+	}
+
+	read := make(map[string]bool)
+	seeded := 0
+	for len(queue) > 0 && len(read) < maxSitemaps {
+		loc := queue[0]
+		queue = queue[1:]
+		if read[loc] {
+			continue
+		}
+		read[loc] = true
+
+		s, err := spider.fetchSitemap(loc)
+		if err != nil {
+			spider.Logger.Debugf("Could not read sitemap %s: %v", loc, err)
+			continue
+		}
+		queue = append(queue, s.Sitemaps...)
+
+		for _, entry := range s.URLs {
+			// a sitemap can only list pages of its own host
+			if pageURL, err := url.Parse(entry.Loc); err != nil || pageURL.Host != u.Host {
+				continue
+			}
+			job, err := spider.newJob(entry.Loc, loc, 1)
+			if err != nil {
+				continue
+			}
+			job.Priority += lastmodPriority(entry.LastMod)
+			spider.discoverJob(job)
+			seeded++
+		}
+	}
+
+	if seeded > 0 {
+		spider.Logger.Infof("Seeded %d jobs from %d sitemaps of %s", seeded, len(read), root)
+	}
+}
+
+func (spider *Spider) fetchSitemap(loc string) (*sitemap.Sitemap, error) {
+	resp, err := spider.client.Get(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	return sitemap.Parse(io.LimitReader(resp.Body, maxSitemapSize))
+}
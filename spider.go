@@ -1,18 +1,20 @@
 package main
 
 import (
+	"context"
 	"crypto/md5"
 	"database/sql/driver"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"hash/fnv"
 	"net"
 	"net/http"
 	"net/url"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	// "https://github.com/rsc/pdf"
@@ -33,13 +35,47 @@ import (
 	"gopkg.in/jdkato/prose.v2"
 
 	"github.com/samirettali/tor-spider/pkg/articletext"
+	"github.com/samirettali/tor-spider/pkg/extract"
 	"github.com/samirettali/tor-spider/pkg/gowap"
+	"github.com/samirettali/tor-spider/pkg/hostlimit"
 	"github.com/samirettali/tor-spider/pkg/manticore"
+	"github.com/samirettali/tor-spider/pkg/proxypool"
+	"github.com/samirettali/tor-spider/pkg/simhash"
+	"github.com/samirettali/tor-spider/pkg/urlnorm"
+	"github.com/samirettali/tor-spider/pkg/warc"
 )
 
 // Job is a struct that represents a job
 type Job struct {
-	URL string
+	ID       string `json:"-"`
+	URL      string
+	Host     string
+	Priority int
+	Depth    int
+	From     string
+	Attempts int
+}
+
+// newJob returns a job for the canonical form of URL discovered from the page
+// at from, at the given number of hops from its seed. Shallower jobs get a
+// higher priority.
+func (spider *Spider) newJob(URL, from string, depth int) (Job, error) {
+	canonical, err := spider.canonicalizer.Canonicalize(URL)
+	if err != nil {
+		return Job{}, err
+	}
+	job := Job{
+		URL:      canonical,
+		From:     from,
+		Depth:    depth,
+		Priority: -depth,
+	}
+	if u, err := url.Parse(canonical); err == nil {
+		job.Host = u.Hostname()
+	}
+	// well linked hosts are crawled first
+	job.Priority += int(spider.hostRanks[job.Host] * rankBoost)
+	return job, nil
 }
 
 // PageInfo is a struct used to save the informations about a visited page
@@ -61,18 +97,22 @@ type PageInfo struct {
 	Language       string          `gorm:"index:language"`
 	LangConfidence float64         `json:"-"`
 	Fingerprint    string          `json:"-" gorm:"index:fingerprint"`
+	SimHash        uint64          `json:"-" gorm:"index:sim_hash"`
+	ClusterID      uint            `json:"cluster_id,omitempty" gorm:"index:cluster_id"`
+	NearDuplicate  string          `json:"near_duplicate,omitempty" gorm:"size:16;index:near_duplicate"`
 	Wapp           string          `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext" json:"-"`
 	PageTopic      []*PageTopic    `gorm:"many2many:page_topics;" json:"-"`
 	PageProperties PageProperties  `sql:"type:text" json:"-"`
 	PageAttributes []PageAttribute `json:"-"`
+	Versions       []PageVersion   `json:"-"`
 }
 
-func (p *PageInfo) BeforeCreate() (err error) {
+func (p *PageInfo) BeforeSave() (err error) {
 	if p.Summary != "" {
 		info := whatlanggo.Detect(p.Summary)
 		p.Language = info.Lang.String()
 		p.LangConfidence = info.Confidence
-		fmt.Println("======> Language:", p.Language, " Script:", whatlanggo.Scripts[info.Script], " Confidence: ", p.LangConfidence)
+		log.Debugf("Detected language %s (script %s, confidence %.2f) for %s", p.Language, whatlanggo.Scripts[info.Script], p.LangConfidence, p.URL)
 	}
 	return
 }
@@ -140,47 +180,115 @@ type Spider struct {
 	results     chan PageInfo
 	proxyURI    string
 
-	regexTwitter *regexp.Regexp
-	regexOnion   *regexp.Regexp
-	regexBitcoin *regexp.Regexp
-	regexEmail   *regexp.Regexp
-
-	manticore   manticore.Client
-	rdbms       *gorm.DB
-	storage     storage.Storage
-	jobsStorage JobsStorage
-	pageStorage PageStorage
-	wapp        *gowap.Wappalyzer
-	Logger      *log.Logger
+	shutdownTimeout time.Duration
+	minRevisit      time.Duration
+	maxRevisit      time.Duration
+	maxRetries      int
+	retryDelay      time.Duration
+	mirrorInterval  time.Duration
+	quit            chan struct{}
+	stopping        bool
+	stopMu          sync.RWMutex
+	feeders         sync.WaitGroup
+	collectors      sync.WaitGroup
+
+	manticore     manticore.Client
+	rdbms         *gorm.DB
+	storage       storage.Storage
+	jobsStorage   JobsStorage
+	pageStorage   PageStorage
+	seen          SeenFilter
+	canonicalizer *urlnorm.Canonicalizer
+	hostRanks     map[string]float64
+	extractors    extract.Pipeline
+	proxies       *proxypool.Pool
+	hosts         *hostlimit.Limiter
+	simhashes     *simhash.Index
+	client        *http.Client
+	obeyRobots    bool
+	sitemaps      bool
+	hashImages    bool
+	offline       bool
+	archive       *warc.Writer
+	robots        map[string]*robotsEntry
+	sitemapHosts  map[string]time.Time
+	robotsMu      sync.Mutex
+	wapp          *gowap.Wappalyzer
+	Logger        *log.Logger
 }
 
 // JobsStorage is an interface which handles the storage of the jobs when it's
-// channel is empty or full.
+// channel is empty or full. Jobs returned by GetJob are leased and must be
+// either acknowledged with AckJob once crawled or given back with NackJob.
 type JobsStorage interface {
 	Init() error
 	SaveJob(Job) error
 	GetJob() (Job, error)
+	AckJob(Job) error
+	NackJob(Job) error
+	Close() error
+}
+
+// SeenFilter is an interface which keeps track of the URLs already enqueued
+type SeenFilter interface {
+	Init() error
+	Seen(string) (bool, error)
 }
 
 // PageStorage is an interface which handles tha storage of the visited pages
 type PageStorage interface {
 	Init() error
 	SavePage(PageInfo) error
+	Close() error
 }
 
 // Init initialized all the struct values
 func (spider *Spider) Init() error {
 	spider.jobs = make(chan Job, spider.numWorkers*spider.parallelism*100)
 	spider.results = make(chan PageInfo, 100)
+	spider.quit = make(chan struct{})
 	spider.startWebServer()
 	//if spider.admin {
 	spider.startWebAdmin()
 	//}
 
+	if spider.canonicalizer == nil {
+		spider.canonicalizer = urlnorm.New()
+	}
+	if spider.extractors == nil {
+		extractors, err := extract.New()
+		if err != nil {
+			return err
+		}
+		spider.extractors = extractors
+	}
+	if spider.hosts == nil {
+		spider.hosts = hostlimit.New(hostlimit.Rule{Concurrency: spider.parallelism})
+	}
+	spider.client = spider.newHTTPClient()
+
+	if spider.simhashes == nil {
+		spider.simhashes = simhash.NewIndex(3)
+	}
+	if err := spider.loadSimHashes(); err != nil {
+		return err
+	}
+
+	if spider.seen != nil {
+		if err := spider.seen.Init(); err != nil {
+			return err
+		}
+	}
+
 	if err := spider.startJobsStorage(); err != nil {
 		return err
 	}
 
+	spider.startScheduler()
+	spider.startRetries()
+	spider.startProxyChecks()
+	spider.startMirrorDetection()
+
 	if err := spider.pageStorage.Init(); err != nil {
 		return err
 	}
@@ -236,6 +344,17 @@ func (spider *Spider) startWebAdmin() {
 
 	Admin.AddResource(&Tag{})
 
+	Admin.AddResource(&UptimeCheck{})
+
+	mirrorClusters := Admin.AddResource(&MirrorCluster{})
+	mirrorClusters.IndexAttrs("ID", "Title", "Size", "Clones")
+
+	mirrorDomains := Admin.AddResource(&MirrorDomain{})
+	mirrorDomains.IndexAttrs("ID", "MirrorClusterID", "Domain", "Clone", "Reason")
+
+	images := Admin.AddResource(&PageImage{})
+	images.IndexAttrs("ID", "Domain", "Kind", "ImageURL", "MD5", "MMH3")
+
 	// initalize an HTTP request multiplexer
 	mux := http.NewServeMux()
 
@@ -254,6 +373,21 @@ func (spider *Spider) startWebAdmin() {
 		c.String(500, "not implemented yet")
 	})
 
+	// add routes to services uptime
+	router.GET("/api/uptime", spider.uptimeHandler)
+	router.GET("/api/uptime/:host", spider.uptimeHandler)
+
+	// add route to pivot on the identifiers found in the pages
+	router.GET("/api/entities/:type/*value", spider.entityHandler)
+
+	// add routes to list the mirrors and phishing clones of the services
+	router.GET("/api/mirrors", spider.mirrorsHandler)
+	router.GET("/api/mirrors/:domain", spider.mirrorHandler)
+
+	// add routes to search the services sharing a favicon or a similar logo
+	router.GET("/api/favicons/:hash", spider.faviconHandler)
+	router.GET("/api/images/:id/similar", spider.similarImagesHandler)
+
 	// add route to add new website
 	router.GET("/add", func(c *gin.Context) {
 		inputUrl, _ := c.GetQuery("url")
@@ -261,8 +395,16 @@ func (spider *Spider) startWebAdmin() {
 			c.String(500, "missing url parameter.")
 			return
 		}
-		spider.Logger.Debugf("Crawling URL: %s", inputUrl)
-		go spider.crawl(inputUrl, true)
+		job, err := spider.newJob(inputUrl, "", 0)
+		if err != nil {
+			c.String(500, err.Error())
+			return
+		}
+		spider.Logger.Debugf("Crawling URL: %s", job.URL)
+		if !spider.goCollector(func() { spider.crawl(job, true) }) {
+			c.String(503, "shutting down")
+			return
+		}
 		c.String(200, "ok")
 	})
 
@@ -295,8 +437,18 @@ func (spider *Spider) startWebServer() {
 			w.Write([]byte("Missing url"))
 			return
 		}
-		spider.Logger.Debugf("Crawling URL: %s", URL)
-		go spider.crawl(URL, true)
+		job, err := spider.newJob(URL, "", 0)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		spider.Logger.Debugf("Crawling URL: %s", job.URL)
+		if !spider.goCollector(func() { spider.crawl(job, true) }) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Shutting down"))
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Oki"))
 	})
@@ -311,9 +463,16 @@ func (spider *Spider) startJobsStorage() error {
 	}
 
 	delay := 50 * time.Millisecond
+	spider.feeders.Add(2)
 	go func() {
+		defer spider.feeders.Done()
 		lowerBound := int(float64(cap(spider.jobs)) * .15)
 		for {
+			select {
+			case <-spider.quit:
+				return
+			default:
+			}
 			if len(spider.jobs) < lowerBound {
 				job, err := spider.jobsStorage.GetJob()
 				if err != nil {
@@ -324,7 +483,7 @@ func (spider *Spider) startJobsStorage() error {
 						spider.Logger.Error(err)
 					}
 				} else {
-					spider.jobs <- job
+					spider.enqueue(job)
 					spider.Logger.Debugf("Got Job %v", job)
 				}
 			} else {
@@ -334,14 +493,16 @@ func (spider *Spider) startJobsStorage() error {
 	}()
 
 	go func() {
+		defer spider.feeders.Done()
 		upperBound := int(float64(cap(spider.jobs)) * .85)
 		for {
+			select {
+			case <-spider.quit:
+				return
+			default:
+			}
 			if len(spider.jobs) > upperBound {
-				job := <-spider.jobs
-				err := spider.jobsStorage.SaveJob(job)
-				if err != nil {
-					log.Error(err)
-				}
+				spider.saveJob(<-spider.jobs)
 			} else {
 				time.Sleep(delay)
 			}
@@ -350,7 +511,75 @@ func (spider *Spider) startJobsStorage() error {
 	return nil
 }
 
-func (spider *Spider) getCollector() (*colly.Collector, error) {
+// enqueue pushes a job to the jobs channel, falling back to the jobs storage
+// when the channel is full so that collectors never block on it
+func (spider *Spider) enqueue(job Job) {
+	select {
+	case spider.jobs <- job:
+	default:
+		spider.saveJob(job)
+	}
+}
+
+// discover enqueues the URL found in the page at from, unless it was already
+// seen inside the revisit window
+func (spider *Spider) discover(URL, from string, depth int) {
+	job, err := spider.newJob(URL, from, depth)
+	if err != nil {
+		spider.Logger.Debugf("Skipping %s: %v", URL, err)
+		return
+	}
+	spider.discoverJob(job)
+}
+
+// discoverJob enqueues job unless its URL was already seen
+func (spider *Spider) discoverJob(job Job) {
+	if spider.seen != nil {
+		seen, err := spider.seen.Seen(job.URL)
+		if err != nil {
+			spider.Logger.Error(err)
+		} else if seen {
+			return
+		}
+	}
+	spider.enqueue(job)
+}
+
+// saveJob moves a job to the jobs storage, releasing its lease if it was
+// already fetched from there
+func (spider *Spider) saveJob(job Job) error {
+	var err error
+	if job.ID != "" {
+		err = spider.jobsStorage.NackJob(job)
+	} else {
+		err = spider.jobsStorage.SaveJob(job)
+	}
+	if err != nil {
+		spider.Logger.Error(err)
+	}
+	return err
+}
+
+// startProxyChecks periodically health-checks the proxies
+func (spider *Spider) startProxyChecks() {
+	spider.feeders.Add(1)
+	go func() {
+		defer spider.feeders.Done()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-spider.quit:
+				return
+			case <-ticker.C:
+				healthy := spider.proxies.Check()
+				spider.Logger.Debugf("%d/%d proxies are healthy", healthy, len(spider.proxies.Endpoints()))
+			}
+		}
+	}()
+}
+
+func (spider *Spider) getCollector(job Job) (*colly.Collector, error) {
 	disallowed := make([]*regexp.Regexp, len(spider.blacklist))
 	for index, b := range spider.blacklist {
 		disallowed[index] = regexp.MustCompile(b)
@@ -377,13 +606,10 @@ func (spider *Spider) getCollector() (*colly.Collector, error) {
 	extensions.RandomUserAgent(c)
 	extensions.Referer(c)
 
-	proxyURL, err := url.Parse(spider.proxyURI)
-	if err != nil {
-		return nil, err
-	}
-
-	c.WithTransport(&http.Transport{
-		Proxy: http.ProxyURL(proxyURL),
+	// the fetch timeout is enforced by the transport, after the host limiter
+	c.SetRequestTimeout(0)
+	c.WithTransport(&politeTransport{limiter: spider.hosts, proxies: spider.proxies, RoundTripper: &http.Transport{
+		Proxy: contextProxy,
 		DialContext: (&net.Dialer{
 			Timeout:   60 * time.Second,
 			KeepAlive: 60 * time.Second,
@@ -394,84 +620,143 @@ func (spider *Spider) getCollector() (*colly.Collector, error) {
 		TLSHandshakeTimeout:   60 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 		DisableKeepAlives:     true,
-	})
+	}})
 
 	c.Limit(&colly.LimitRule{
 		DomainGlob:  "*",
 		Parallelism: spider.parallelism,
 	})
 
-	if err := c.SetStorage(spider.storage); err != nil {
+	if spider.obeyRobots {
+		c.OnRequest(spider.checkRobots)
+	}
+
+	if err := c.SetStorage(&seedStorage{Storage: spider.storage, seed: job.URL}); err != nil {
 		return nil, err
 	}
 
 	// Get all the links
 	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
-		foundURL := e.Request.AbsoluteURL(e.Attr("href"))
-		if foundURL != "" && e.Request.Depth == spider.depth {
-			spider.jobs <- Job{foundURL}
+		foundURL, err := spider.canonicalizer.Canonicalize(e.Request.AbsoluteURL(e.Attr("href")))
+		if err != nil {
+			return
+		}
+		if e.Request.Depth == spider.depth {
+			spider.discover(foundURL, e.Request.URL.String(), job.Depth+e.Request.Depth)
 		} else {
 			e.Request.Visit(foundURL)
 		}
 	})
 
+	// Archive the raw responses
+	if spider.archive != nil {
+		c.OnResponse(spider.archiveResponse)
+	}
+
 	// Save result
+	c.OnResponse(spider.processResponse)
+
+	// Debug responses
 	c.OnResponse(func(r *colly.Response) {
-		body := string(r.Body)
-		bodyReader := strings.NewReader(body)
+		spider.Logger.Debugf("Got %d for %s", r.StatusCode,
+			r.Request.URL)
+	})
 
-		dom, err := goquery.NewDocumentFromReader(bodyReader)
-		title := ""
-		if err != nil {
-			spider.Logger.Error(err)
-			return
-		} else {
-			title = dom.Find("title").Contents().Text()
+	// Debug errors
+	c.OnError(func(r *colly.Response, err error) {
+		spider.Logger.Debugf("Error while visiting %s: %v", r.Request.URL, err)
+		domain := ""
+		if u, err := tld.Parse(r.Request.URL.String()); err == nil {
+			domain = u.Domain
 		}
-
-		if title == "" {
-			spider.Logger.Error(errors.New("not an html page"))
-			return
+		spider.recordVisit(r.Request.URL.String(), domain, r.StatusCode, "")
+		kind := classifyFailure(r.StatusCode, r.Body, err)
+		spider.recordFailure(r.Request.URL.String(), domain, kind, err)
+
+		// the transport reports the errors of the proxies, except the ones
+		// described by the error pages of privoxy
+		if kind == FailureSocks && r.Headers != nil && r.Headers.Get(proxyHeader) != "" {
+			if err := spider.proxies.ReportFailure(r.Headers.Get(proxyHeader)); err != nil {
+				spider.Logger.Error(err)
+			}
 		}
+	})
 
-		text, err := articletext.GetArticleTextFromDocument(dom)
-		if err != nil {
-			spider.Logger.Error(err)
-		}
+	return c, nil
+}
+
+// processResponse extracts, saves and indexes a crawled page. Offline, when
+// replaying archived responses, nothing is fetched and no job is discovered.
+func (spider *Spider) processResponse(r *colly.Response) {
+	body := string(r.Body)
+	bodyReader := strings.NewReader(body)
+
+	dom, err := goquery.NewDocumentFromReader(bodyReader)
+	title := ""
+	if err != nil {
+		spider.Logger.Error(err)
+		return
+	} else {
+		title = dom.Find("title").Contents().Text()
+	}
 
-		// extract the domain vanity hash
-		u, _ := tld.Parse(r.Request.URL.String())
-		spider.Logger.Debugf("[parseDomain] subdomain=%s, domain=%s", u.Subdomain, u.Domain)
+	if title == "" {
+		spider.Logger.Error(errors.New("not an html page"))
+		return
+	}
 
-		// extract a md5 hash of the text to avoid duplicate content (login pages, captachas,...)
-		fingerprint := strToMD5(text)
+	text, err := articletext.GetArticleTextFromDocument(dom)
+	if err != nil {
+		spider.Logger.Error(err)
+	}
 
-		// check if home page
-		home, err := url.Parse(r.Request.URL.String())
-		if err != nil {
-			spider.Logger.Error(err)
-		}
+	// extract the domain vanity hash
+	u, _ := tld.Parse(r.Request.URL.String())
+	spider.Logger.Debugf("[parseDomain] subdomain=%s, domain=%s", u.Subdomain, u.Domain)
 
-		// extract key points
-		s := summarize.NewFromString(title, text)
-		keyPoints := s.KeyPoints()
-
-		result := &PageInfo{
-			URL:         r.Request.URL.String(),
-			Summary:     text,
-			KeyPoints:   strings.Join(keyPoints, "|"),
-			Domain:      u.Domain,
-			Status:      r.StatusCode,
-			Title:       title,
-			Fingerprint: fingerprint,
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
-		}
+	// extract a md5 hash of the text to detect changes, and a simhash to
+	// cluster near duplicate content (login pages, captchas, mirrors,...)
+	fingerprint := strToMD5(text)
+
+	// check if home page
+	home, err := url.Parse(r.Request.URL.String())
+	if err != nil {
+		spider.Logger.Error(err)
+	}
+
+	// replayed responses are dated when they were archived
+	crawledAt := time.Now()
+	if date, ok := r.Ctx.GetAny("crawledAt").(time.Time); ok {
+		crawledAt = date
+	}
+	// an outdated replay must not rewrite the links and entities of the page
+	if spider.offline && spider.storedAfter(r.Request.URL.String(), crawledAt) {
+		spider.Logger.Debugf("link=%s archived at %v is older than the stored page", r.Request.URL, crawledAt)
+		return
+	}
+
+	// extract key points
+	s := summarize.NewFromString(title, text)
+	keyPoints := s.KeyPoints()
+
+	result := &PageInfo{
+		URL:         r.Request.URL.String(),
+		Summary:     text,
+		KeyPoints:   strings.Join(keyPoints, "|"),
+		Domain:      u.Domain,
+		Status:      r.StatusCode,
+		Title:       title,
+		Fingerprint: fingerprint,
+		SimHash:     simhash.Hash(text),
+		CreatedAt:   crawledAt,
+		UpdatedAt:   crawledAt,
+	}
 
-		// var isHomePage bool
-		if home.RequestURI() == "" || home.RequestURI() == "/" {
-			result.IsHomePage = true
-			// gowap the tor-website
+	// var isHomePage bool
+	if home.RequestURI() == "" || home.RequestURI() == "/" {
+		result.IsHomePage = true
+		// gowap the tor-website
+		if !spider.offline {
 			res, err := spider.wapp.Analyze(r.Request.URL.String())
 			if err != nil {
 				spider.Logger.Error(err)
@@ -484,93 +769,87 @@ func (spider *Spider) getCollector() (*colly.Collector, error) {
 			result.Wapp = string(wappJson)
 		}
 
-		// check for bitcoins and email addresses
-		emails := spider.regexEmail.FindAllString(body, -1)
-		emails = removeDuplicates(emails)
-		for _, email := range emails {
-			result.PageAttributes = append(result.PageAttributes, PageAttribute{Name: "email", Value: email})
-			result.PageProperties = append(result.PageProperties, PageProperty{Name: "email", Value: email})
-		}
-		bitcoins := spider.regexBitcoin.FindAllString(body, -1)
-		bitcoins = removeDuplicates(bitcoins)
-		for _, bitcoin := range bitcoins {
-			result.PageAttributes = append(result.PageAttributes, PageAttribute{Name: "bitcoin", Value: bitcoin})
-			result.PageProperties = append(result.PageProperties, PageProperty{Name: "bitcoin", Value: bitcoin})
+		if spider.hashImages && !spider.offline {
+			spider.savePageImages(r.Request.URL, result.Domain, dom)
 		}
+	}
 
-		twitters := spider.regexTwitter.FindAllString(body, -1)
-		twitters = removeDuplicates(twitters)
-		for _, twitter := range twitters {
-			result.PageAttributes = append(result.PageAttributes, PageAttribute{Name: "twitter", Value: twitter})
-			result.PageProperties = append(result.PageProperties, PageProperty{Name: "twitter", Value: twitter})
+	// run the extractors
+	page := &extract.Page{
+		URL:    r.Request.URL,
+		Header: *r.Headers,
+		Body:   r.Body,
+		DOM:    dom,
+		Text:   text,
+	}
+	var onions []string
+	attrs := spider.extractors.Extract(page)
+	for _, attr := range attrs {
+		result.PageAttributes = append(result.PageAttributes, PageAttribute{Name: attr.Name, Value: attr.Value})
+		result.PageProperties = append(result.PageProperties, PageProperty{Name: attr.Name, Value: attr.Value})
+		if attr.Name == extract.Onion {
+			onions = append(onions, attr.Value)
 		}
-
-		/*
-			onions := spider.regexOnion.FindAllString(body, -1)
-			for _, onion := range onions {
-				result.PageAttributes = append(result.PageAttributes, PageAttribute{Name: "outbound", Value: onion})
-				result.PageProperties = append(result.PageProperties, PageProperty{Name: "outbound", Value: onion})
-				spider.jobs <- Job{onion}
-			}
-		*/
-
-		// keywords
-		var topicsProse []string
-		doc, _ := prose.NewDocument(text)
-		for _, ent := range doc.Entities() {
-			spider.Logger.Debugf("[entity] ent.Text=%s, ent.Label=%s", ent.Text, ent.Label)
-			topic := ent.Text
-			if len(topic) > 16 {
-				continue
-			}
-			if topic != "" {
-				topicsProse = append(topicsProse, topic)
-			}
+	}
+	if spider.extractors.Has(extract.PGP) {
+		spider.savePublicKeys(result.URL, result.Domain, extract.FindPublicKeys(page))
+	}
+	spider.indexEntities(result.URL, result.Domain, attrs, crawledAt)
+
+	// keywords
+	var topicsProse []string
+	doc, _ := prose.NewDocument(text)
+	for _, ent := range doc.Entities() {
+		spider.Logger.Debugf("[entity] ent.Text=%s, ent.Label=%s", ent.Text, ent.Label)
+		topic := ent.Text
+		if len(topic) > 16 {
+			continue
 		}
-		topicsProse = removeDuplicates(topicsProse)
-		result.Keywords = strings.Join(topicsProse, ",")
-
-		var pageExists PageInfo
-		if !spider.rdbms.Where("fingerprint = ?", fingerprint).First(&pageExists).RecordNotFound() {
-			spider.Logger.Debugf("skipping link=%s as similar content already exists\n", r.Request.URL.String())
-			// if simalar content exists, skip from mysql and elasticsearch indexation
-			return
-		} else {
-			spider.Logger.Debug("Insert into db...")
-			err = spider.rdbms.Create(result).Error
-			if err != nil {
-				spider.Logger.Error(err)
-				return
-			}
+		if topic != "" {
+			topicsProse = append(topicsProse, topic)
 		}
+	}
+	topicsProse = removeDuplicates(topicsProse)
+	result.Keywords = strings.Join(topicsProse, ",")
 
-		// index to manticoresearch
-		// how to cope with the new manticore json api ?!
-		// curl -X POST 'http://127.0.0.1:9308/json/insert' -d'{"index":"testrt","id":1,"doc":{"title":"Hello","content":"world","gid":1}}'
+	// the schedule and the failures are those of the live crawl
+	if !spider.offline {
+		spider.recordVisit(result.URL, result.Domain, result.Status, fingerprint)
+		spider.clearFailure(result.URL)
+	}
 
-		// index to elasticsearch
-		err = spider.pageStorage.SavePage(*result)
-		if err != nil {
-			spider.Logger.Error(err)
+	// save the outbound links and the onion addresses mentioned in the
+	// page to the link graph, and crawl the new services
+	links := spider.extractLinks(r.Request.URL, dom)
+	for _, onion := range onions {
+		if onion == r.Request.URL.Hostname() {
+			continue
 		}
+		addOnionReference(links, onion)
+		if !spider.offline {
+			spider.discover("http://"+onion+"/", result.URL, 0)
+		}
+	}
+	if err := spider.saveLinks(result.URL, links); err != nil {
+		spider.Logger.Error(err)
+	}
 
-	})
-
-	// Debug responses
-	c.OnResponse(func(r *colly.Response) {
-		spider.Logger.Debugf("Got %d for %s", r.StatusCode,
-			r.Request.URL)
-	})
-
-	// Debug errors
-	c.OnError(func(r *colly.Response, err error) {
-		spider.Logger.Debugf("Error while visiting %s: %v", r.Request.URL, err)
-	})
+	// a database error does not keep the page from the other storages
+	saved, err := spider.savePageInfo(result)
+	if err != nil {
+		spider.Logger.Error(err)
+	} else if !saved {
+		return
+	}
 
-	return c, nil
+	// index to the page storages
+	err = spider.pageStorage.SavePage(*result)
+	if err != nil {
+		spider.Logger.Error(err)
+	}
 }
 
-func (spider *Spider) getInputCollector() (*colly.Collector, error) {
+func (spider *Spider) getInputCollector(job Job) (*colly.Collector, error) {
 	c := colly.NewCollector(
 		colly.MaxDepth(3),
 		colly.Async(true),
@@ -582,13 +861,9 @@ func (spider *Spider) getInputCollector() (*colly.Collector, error) {
 	extensions.RandomUserAgent(c)
 	extensions.Referer(c)
 
-	proxyURL, err := url.Parse(spider.proxyURI)
-	if err != nil {
-		return nil, err
-	}
-
-	c.WithTransport(&http.Transport{
-		Proxy: http.ProxyURL(proxyURL),
+	c.SetRequestTimeout(0)
+	c.WithTransport(&politeTransport{limiter: spider.hosts, proxies: spider.proxies, RoundTripper: &http.Transport{
+		Proxy: contextProxy,
 		DialContext: (&net.Dialer{
 			Timeout: 60 * time.Second,
 			// KeepAlive: 30 * time.Second,
@@ -599,17 +874,24 @@ func (spider *Spider) getInputCollector() (*colly.Collector, error) {
 		TLSHandshakeTimeout:   60 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 		DisableKeepAlives:     true,
-	})
+	}})
 
 	c.Limit(&colly.LimitRule{
 		DomainGlob:  "*",
 		Parallelism: spider.parallelism,
 	})
 
+	if spider.obeyRobots {
+		c.OnRequest(spider.checkRobots)
+	}
+
 	// Get all the links
 	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
-		foundURL := e.Request.AbsoluteURL(e.Attr("href"))
-		spider.jobs <- Job{foundURL}
+		foundURL, err := spider.canonicalizer.Canonicalize(e.Request.AbsoluteURL(e.Attr("href")))
+		if err != nil {
+			return
+		}
+		spider.discover(foundURL, e.Request.URL.String(), job.Depth+e.Request.Depth)
 		e.Request.Visit(foundURL)
 	})
 
@@ -628,35 +910,144 @@ func (spider *Spider) getInputCollector() (*colly.Collector, error) {
 	return c, nil
 }
 
-// Start starts the crawlers and logs messages
-func (spider *Spider) Start() {
+// Start starts the crawlers and logs messages until ctx is cancelled, then it
+// stops the spider saving all the pending data
+func (spider *Spider) Start(ctx context.Context) error {
 	sem := make(chan int, spider.numWorkers)
+	// the dispatcher is a feeder, so that Stop waits for it before the
+	// collectors
+	spider.feeders.Add(1)
 	go func() {
+		defer spider.feeders.Done()
 		for {
-			job := <-spider.jobs
-			sem <- 1
-			go func(seed string) {
-				spider.crawl(seed, false)
-				<-sem
-			}(job.URL)
+			select {
+			case <-ctx.Done():
+				return
+			case <-spider.quit:
+				return
+			case job := <-spider.jobs:
+				select {
+				case sem <- 1:
+				case <-ctx.Done():
+					spider.enqueue(job)
+					return
+				case <-spider.quit:
+					spider.enqueue(job)
+					return
+				}
+				started := spider.goCollector(func() {
+					spider.crawl(job, false)
+					if err := spider.jobsStorage.AckJob(job); err != nil {
+						spider.Logger.Error(err)
+					}
+					<-sem
+				})
+				if !started {
+					spider.enqueue(job)
+					<-sem
+					return
+				}
+			}
 		}
 	}()
 
 	ticker := time.NewTicker(1 * time.Second)
-	for range ticker.C {
-		spider.Logger.Infof("There are %d jobs and %d collectors running", len(spider.jobs), len(sem))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			spider.Logger.Infof("Shutting down, %d collectors still running", len(sem))
+			return spider.Stop()
+		case <-ticker.C:
+			spider.Logger.Infof("There are %d jobs and %d collectors running", len(spider.jobs), len(sem))
+		}
+	}
+}
+
+// goCollector runs crawl in a new collector goroutine, unless the spider is
+// stopping. It is safe to call concurrently with Stop.
+func (spider *Spider) goCollector(crawl func()) bool {
+	spider.stopMu.RLock()
+	defer spider.stopMu.RUnlock()
+	if spider.stopping {
+		return false
 	}
+	spider.collectors.Add(1)
+	go func() {
+		defer spider.collectors.Done()
+		crawl()
+	}()
+	return true
 }
 
-func (spider *Spider) crawl(seed string, input bool) {
+// Stop stops the jobs feeders, waits for the running collectors up to the
+// shutdown timeout and persists every queued job and buffered page
+func (spider *Spider) Stop() error {
+	// no collector is started once stopping is set, so the collectors
+	// WaitGroup is not added to while it is waited on
+	spider.stopMu.Lock()
+	spider.stopping = true
+	close(spider.quit)
+	spider.stopMu.Unlock()
+	spider.feeders.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		spider.collectors.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		spider.Logger.Info("All collectors stopped")
+	case <-time.After(spider.shutdownTimeout):
+		spider.Logger.Warnf("Collectors still running after %v, saving data anyway", spider.shutdownTimeout)
+	}
+
+	saved := 0
+	var jobsErr error
+	for drained := false; !drained; {
+		select {
+		case job := <-spider.jobs:
+			if err := spider.saveJob(job); err != nil {
+				jobsErr = err
+				continue
+			}
+			saved++
+		default:
+			drained = true
+		}
+	}
+	spider.Logger.Infof("Saved %d queued jobs", saved)
+
+	if err := spider.jobsStorage.Close(); err != nil {
+		jobsErr = err
+	}
+	if spider.archive != nil {
+		if err := spider.archive.Close(); err != nil {
+			spider.Logger.Error(err)
+		}
+	}
+	if err := spider.pageStorage.Close(); err != nil {
+		return err
+	}
+	return jobsErr
+}
+
+func (spider *Spider) crawl(job Job, input bool) {
 	var c *colly.Collector
 	var err error
+	seed := job.URL
 	spider.Logger.Debugf("seed=%s, input=%t", seed, input)
 
+	if spider.sitemaps {
+		spider.discoverSitemaps(seed)
+	}
+
 	if input {
-		c, err = spider.getInputCollector()
+		c, err = spider.getInputCollector(job)
 	} else {
-		c, err = spider.getCollector()
+		c, err = spider.getCollector(job)
 	}
 
 	if err != nil {
@@ -673,6 +1064,29 @@ func (spider *Spider) crawl(seed string, input bool) {
 	c.Wait()
 }
 
+// seedStorage lets a collector visit its seed once even if it was already
+// visited, as the frontier is in charge of deciding when a page has to be
+// crawled again
+type seedStorage struct {
+	storage.Storage
+	seed string
+	once sync.Once
+}
+
+// IsVisited returns false the first time it is asked about the seed
+func (s *seedStorage) IsVisited(requestID uint64) (bool, error) {
+	h := fnv.New64a()
+	h.Write([]byte(s.seed))
+	if requestID == h.Sum64() {
+		first := false
+		s.once.Do(func() { first = true })
+		if first {
+			return false, nil
+		}
+	}
+	return s.Storage.IsVisited(requestID)
+}
+
 func removeDuplicates(elements []string) []string {
 	// Use map to record duplicates as we find them.
 	encountered := map[string]bool{}